@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types and classes used by the DNS source
+const (
+	dnsTypeA    uint16 = 1
+	dnsTypeTXT  uint16 = 16
+	dnsTypeAAAA uint16 = 28
+
+	dnsClassIN    uint16 = 1
+	dnsClassCHAOS uint16 = 3
+
+	dnsHeaderLength int = 12
+)
+
+var (
+	invalidDNSResponseErr = errors.New("invalid DNS response")
+	noDNSAnswerErr        = errors.New("no usable answer in the DNS response")
+)
+
+// A special DNS query that returns the address of whoever sends it.
+type dnsProvider struct {
+	name string
+	// The TXT record type returns the address as text, otherwise the record type for the IP version is used.
+	useTXT bool
+	class  uint16
+	// The servers that answer the query for each IP version. They have to be queried directly, not through another resolver.
+	servers map[IPVersion][]string
+}
+
+var dnsProviders = map[string]dnsProvider{
+	"cloudflare": {
+		name:   "whoami.cloudflare",
+		useTXT: true,
+		class:  dnsClassCHAOS,
+		servers: map[IPVersion][]string{
+			IPv4: {"1.1.1.1", "1.0.0.1"},
+			IPv6: {"2606:4700:4700::1111", "2606:4700:4700::1001"},
+		},
+	},
+	"opendns": {
+		name:  "myip.opendns.com",
+		class: dnsClassIN,
+		servers: map[IPVersion][]string{
+			IPv4: {"208.67.222.222", "208.67.220.220"},
+			IPv6: {"2620:119:35::35", "2620:119:53::53"},
+		},
+	},
+	"google": {
+		name:   "o-o.myaddr.l.google.com",
+		useTXT: true,
+		class:  dnsClassIN,
+		servers: map[IPVersion][]string{
+			// ns1.google.com
+			IPv4: {"216.239.32.10"},
+			IPv6: {"2001:4860:4802:32::a"},
+		},
+	},
+}
+
+// An IPSource that gets the address with a special DNS query, such as whoami.cloudflare or myip.opendns.com.
+// The query is sent over the IP version being checked.
+type dnsSource struct {
+	provider string
+	// Overrides the provider's servers. host or host:port.
+	servers []string
+}
+
+func (s *dnsSource) Name() string {
+	return "dns " + s.provider
+}
+
+func (s *dnsSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	provider := dnsProviders[s.provider]
+
+	servers := s.servers
+	if len(servers) == 0 {
+		servers = provider.servers[version]
+	}
+
+	queryType := dnsTypeA
+	if provider.useTXT {
+		queryType = dnsTypeTXT
+	} else if version == IPv6 {
+		queryType = dnsTypeAAAA
+	}
+
+	var errs []error
+	for _, server := range servers {
+		address, err := queryDNSForIP(ctx, server, version, provider.name, queryType, provider.class)
+		if err == nil {
+			return address, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Sends the query to the server over the IP version and returns the first address in the answer.
+func queryDNSForIP(ctx context.Context, server string, version IPVersion, name string, queryType, class uint16) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	network := "udp4"
+	if version == IPv6 {
+		network = "udp6"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	idBytes := make([]byte, 2)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes)
+
+	query, err := buildDNSQuery(id, name, queryType, class)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		answers, err := parseDNSResponse(buf[:n], id, queryType)
+		// Ignore answers to other queries
+		if errors.Is(err, invalidDNSResponseErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, answer := range answers {
+			if address := net.ParseIP(answer); address != nil {
+				return address, nil
+			}
+		}
+		return nil, noDNSAnswerErr
+	}
+}
+
+// Builds a DNS query for a single question.
+func buildDNSQuery(id uint16, name string, queryType, class uint16) ([]byte, error) {
+	query := make([]byte, dnsHeaderLength, 512)
+	binary.BigEndian.PutUint16(query[0:2], id)
+	// Recursion desired, needed by some resolvers to answer their special names
+	binary.BigEndian.PutUint16(query[2:4], 0x0100)
+	// One question
+	binary.BigEndian.PutUint16(query[4:6], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+
+	query = binary.BigEndian.AppendUint16(query, queryType)
+	query = binary.BigEndian.AppendUint16(query, class)
+	return query, nil
+}
+
+// Returns the answers of type queryType in the response as text. A and AAAA records are converted to strings,
+// TXT records have their strings joined.
+func parseDNSResponse(resp []byte, id uint16, queryType uint16) ([]string, error) {
+	if len(resp) < dnsHeaderLength || binary.BigEndian.Uint16(resp[0:2]) != id {
+		return nil, invalidDNSResponseErr
+	}
+
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	// QR bit, it has to be a response
+	if flags&0x8000 == 0 {
+		return nil, invalidDNSResponseErr
+	}
+	if rcode := flags & 0x000F; rcode != 0 {
+		return nil, fmt.Errorf("DNS error code %d", rcode)
+	}
+	if flags&0x0200 != 0 {
+		return nil, errors.New("truncated DNS response")
+	}
+
+	questions := int(binary.BigEndian.Uint16(resp[4:6]))
+	answerCount := int(binary.BigEndian.Uint16(resp[6:8]))
+
+	offset := dnsHeaderLength
+	var err error
+	for i := 0; i < questions; i++ {
+		if offset, err = skipDNSName(resp, offset); err != nil {
+			return nil, err
+		}
+		// Type and class
+		offset += 4
+	}
+
+	var answers []string
+	for i := 0; i < answerCount; i++ {
+		if offset, err = skipDNSName(resp, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(resp) {
+			return nil, invalidDNSResponseErr
+		}
+
+		rrType := binary.BigEndian.Uint16(resp[offset : offset+2])
+		rdLength := int(binary.BigEndian.Uint16(resp[offset+8 : offset+10]))
+		offset += 10
+		if offset+rdLength > len(resp) {
+			return nil, invalidDNSResponseErr
+		}
+		rdata := resp[offset : offset+rdLength]
+		offset += rdLength
+
+		if rrType != queryType {
+			continue
+		}
+
+		switch rrType {
+		case dnsTypeA, dnsTypeAAAA:
+			if len(rdata) == net.IPv4len || len(rdata) == net.IPv6len {
+				answers = append(answers, net.IP(rdata).String())
+			}
+		case dnsTypeTXT:
+			var txt strings.Builder
+			for len(rdata) > 0 {
+				length := int(rdata[0])
+				if 1+length > len(rdata) {
+					return nil, invalidDNSResponseErr
+				}
+				txt.Write(rdata[1 : 1+length])
+				rdata = rdata[1+length:]
+			}
+			answers = append(answers, txt.String())
+		}
+	}
+
+	if len(answers) == 0 {
+		return nil, noDNSAnswerErr
+	}
+	return answers, nil
+}
+
+// Returns the offset after the name that starts at offset. Handles compression pointers.
+func skipDNSName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, invalidDNSResponseErr
+		}
+
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xC0 == 0xC0:
+			// A pointer is 2 bytes and ends the name
+			if offset+2 > len(msg) {
+				return 0, invalidDNSResponseErr
+			}
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Starts a fake DNS server that answers every query with a single record of the type asked, with the value of answer
+func newFakeDNSServer(t *testing.T, answer net.IP) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			query := buf[:n]
+			questionEnd, err := skipDNSName(query, dnsHeaderLength)
+			if err != nil {
+				continue
+			}
+			queryType := binary.BigEndian.Uint16(query[questionEnd : questionEnd+2])
+			class := binary.BigEndian.Uint16(query[questionEnd+2 : questionEnd+4])
+
+			var rdata []byte
+			switch queryType {
+			case dnsTypeTXT:
+				rdata = append([]byte{byte(len(answer.String()))}, answer.String()...)
+			case dnsTypeA:
+				rdata = answer.To4()
+			case dnsTypeAAAA:
+				rdata = answer.To16()
+			}
+
+			resp := append([]byte{}, query[:questionEnd+4]...)
+			// Response, recursion desired and available
+			binary.BigEndian.PutUint16(resp[2:4], 0x8180)
+			binary.BigEndian.PutUint16(resp[6:8], 1)
+
+			// The answer's name is a pointer to the question's name
+			resp = append(resp, 0xC0, byte(dnsHeaderLength))
+			resp = binary.BigEndian.AppendUint16(resp, queryType)
+			resp = binary.BigEndian.AppendUint16(resp, class)
+			resp = binary.BigEndian.AppendUint32(resp, 0)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+			resp = append(resp, rdata...)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestDNSSource(t *testing.T) {
+	server := newFakeDNSServer(t, net.ParseIP("192.0.2.53"))
+	defer server.Close()
+
+	for _, provider := range []string{"cloudflare", "opendns", "google"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		source := &dnsSource{provider: provider, servers: []string{server.LocalAddr().String()}}
+		address, err := source.GetIP(ctx, IPv4)
+		cancel()
+
+		if err != nil {
+			t.Errorf("%s: %s", provider, err)
+			continue
+		}
+		if !address.Equal(net.ParseIP("192.0.2.53")) {
+			t.Errorf("%s: Expected 192.0.2.53, got %s", provider, address)
+		}
+	}
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	query, err := buildDNSQuery(0x1234, "whoami.cloudflare", dnsTypeTXT, dnsClassCHAOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0,
+		6, 'w', 'h', 'o', 'a', 'm', 'i', 10, 'c', 'l', 'o', 'u', 'd', 'f', 'l', 'a', 'r', 'e', 0,
+		0, 16, 0, 3}
+	if string(query) != string(expected) {
+		t.Errorf("Unexpected query: %v", query)
+	}
+
+	if _, err := buildDNSQuery(1, "bad..name", dnsTypeA, dnsClassIN); err == nil {
+		t.Error("Expected an error for an empty label")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+)
+
+// The formats of the responses that an httpSource can read.
+const (
+	// The body is only the IP address
+	formatText string = "text"
+	// The body is JSON with the address at jsonPath
+	formatJSON string = "json"
+	// The body is Cloudflare's /cdn-cgi/trace format, the address is in the "ip=" line
+	formatTrace string = "trace"
+)
+
+// An IPSource that gets the address from an HTTP(S) service.
+type httpSource struct {
+	name     string
+	url      string
+	format   string
+	jsonPath string
+	// A client that only connects over the IP version of the source. Otherwise a v4 address could be returned for v6.
+	client *http.Client
+}
+
+func newHTTPSource(name, url, format, jsonPath string, version IPVersion) *httpSource {
+	network := "tcp4"
+	if version == IPv6 {
+		network = "tcp6"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{}
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &httpSource{name: name, url: url, format: format, jsonPath: jsonPath, client: &http.Client{Transport: transport}}
+}
+
+func (s *httpSource) Name() string {
+	return s.name
+}
+
+func (s *httpSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error sending get IP%s request: %w", string(version), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	// No IP source should return more than this
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response: %w", err)
+	}
+
+	addrStr, err := s.extractAddress(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if addrStr == "" {
+		return nil, noIPAddressFoundErr
+	}
+
+	// The main reason for this is to make it easier to compare values, specially for v6 since it can be in different formats.
+	address := net.ParseIP(addrStr)
+	if address == nil {
+		return nil, invalidIPAddressErr
+	}
+
+	return address, nil
+}
+
+// Gets the IP address from the body of the response.
+func (s *httpSource) extractAddress(body []byte) (string, error) {
+	switch s.format {
+	case formatJSON:
+		jsonParsed, err := gabs.ParseJSON(body)
+		if err != nil {
+			return "", FailedToDecodeJSONErr
+		}
+		address, ok := jsonParsed.Path(s.jsonPath).Data().(string)
+		if !ok {
+			return "", fmt.Errorf("no string at %s in the response", s.jsonPath)
+		}
+		return strings.TrimSpace(address), nil
+	case formatTrace:
+		scanner := bufio.NewScanner(strings.NewReader(string(body)))
+		for scanner.Scan() {
+			if address, found := strings.CutPrefix(scanner.Text(), "ip="); found {
+				return strings.TrimSpace(address), nil
+			}
+		}
+		return "", noIPAddressFoundErr
+	default:
+		return strings.TrimSpace(string(body)), nil
+	}
+}
@@ -20,10 +20,11 @@ type IPCache struct {
 	Time time.Time `json:"Time" binding:"required"`
 }
 
-func getCachedIP(version IPVersion) (IPCache, error) {
+// Gets the cache for the record and IPVersion specified.
+func getCachedIP(record *Record, version IPVersion) (IPCache, error) {
 	recordType := version.getRecordType()
 
-	path := getCacheFilePath(recordType)
+	path := getCacheFilePath(record.fqdn, recordType)
 
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -39,9 +40,9 @@ func getCachedIP(version IPVersion) (IPCache, error) {
 	return cache, nil
 }
 
-// Sets the cache for the IPVersion specified. If it fails, the error gets logged.
-func setCachedIP(address net.IP, version IPVersion) {
-	if conf.DisableCFCache {
+// Sets the cache for the record and IPVersion specified. If it fails, the error gets logged.
+func setCachedIP(record *Record, address net.IP, version IPVersion) {
+	if conf.DisableCFCache || dryRun {
 		return
 	}
 
@@ -55,7 +56,7 @@ func setCachedIP(address net.IP, version IPVersion) {
 		return
 	}
 
-	path := getCacheFilePath(recordType)
+	path := getCacheFilePath(record.fqdn, recordType)
 	// Everybody can RX, only owner can W
 	err = os.MkdirAll(filepath.Dir(path), 0755)
 	if err != nil {
@@ -79,6 +80,6 @@ func setCachedIP(address net.IP, version IPVersion) {
 	log.WithFields(log.Fields{"path": path}).Debug("[setCachedIP] Cache Set")
 }
 
-func getCacheFilePath(recordType string) string {
-	return filepath.Join(os.TempDir(), "ddns-cf-cache", conf.name+"-"+recordType+".json")
+func getCacheFilePath(fqdn string, recordType string) string {
+	return filepath.Join(os.TempDir(), "ddns-cf-cache", fqdn+"-"+recordType+".json")
 }
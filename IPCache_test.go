@@ -11,11 +11,13 @@ import (
 // Run the tests using the command go test
 // and run the tests and the benchmarks with go test -bench=.
 
+var testRecord = &Record{Name: "test", fqdn: "test.example.com"}
+
 // Test setting and then getting the cache
 func TestCacheRoundTrip(t *testing.T) {
 	ip := net.ParseIP("192.168.1.1")
-	setCachedIP(ip, IPv4)
-	cache, err := getCachedIP(IPv4)
+	setCachedIP(testRecord, ip, IPv4)
+	cache, err := getCachedIP(testRecord, IPv4)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -31,8 +33,8 @@ func TestCacheRoundTrip(t *testing.T) {
 
 // Make sure the timestamp is recent after a set
 func TestCacheTimeIsRecent(t *testing.T) {
-	setCachedIP(net.ParseIP("127.0.0.1"), IPv4)
-	cache, err := getCachedIP(IPv4)
+	setCachedIP(testRecord, net.ParseIP("127.0.0.1"), IPv4)
+	cache, err := getCachedIP(testRecord, IPv4)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,10 +49,10 @@ func TestDisableCFCachePreventsWrite(t *testing.T) {
 	defer func() { conf.DisableCFCache = false }()
 
 	// Remove any existing cache first
-	os.Remove(getCacheFilePath("A"))
-	setCachedIP(net.ParseIP("1.2.3.4"), IPv4)
+	os.Remove(getCacheFilePath(testRecord.fqdn, "A"))
+	setCachedIP(testRecord, net.ParseIP("1.2.3.4"), IPv4)
 
-	_, err := getCachedIP(IPv4)
+	_, err := getCachedIP(testRecord, IPv4)
 	if err == nil {
 		t.Error("Expected error when cache is disabled, but got none")
 	}
@@ -58,8 +60,8 @@ func TestDisableCFCachePreventsWrite(t *testing.T) {
 
 // Test handling a missing file
 func TestGetCachedIPMissingFile(t *testing.T) {
-	os.Remove(getCacheFilePath(IPv6.getRecordType()))
-	_, err := getCachedIP(IPv6)
+	os.Remove(getCacheFilePath(testRecord.fqdn, IPv6.getRecordType()))
+	_, err := getCachedIP(testRecord, IPv6)
 	if err == nil {
 		t.Error("Expected error for missing cache file, got nil")
 	}
@@ -67,12 +69,27 @@ func TestGetCachedIPMissingFile(t *testing.T) {
 
 // Test handling a corrupted file
 func TestGetCachedIPCorruptFile(t *testing.T) {
-	path := getCacheFilePath(IPv4.getRecordType())
+	path := getCacheFilePath(testRecord.fqdn, IPv4.getRecordType())
 	os.MkdirAll(filepath.Dir(path), 0755)
 	os.WriteFile(path, []byte("not valid json{{{"), 0664)
 
-	_, err := getCachedIP(IPv4)
+	_, err := getCachedIP(testRecord, IPv4)
 	if err == nil {
 		t.Error("Expected error for corrupt cache file, got nil")
 	}
 }
+
+// Make sure that each record gets its own cache
+func TestCachePerRecord(t *testing.T) {
+	other := &Record{Name: "other", fqdn: "other.example.com"}
+	setCachedIP(testRecord, net.ParseIP("192.0.2.1"), IPv4)
+	setCachedIP(other, net.ParseIP("192.0.2.2"), IPv4)
+
+	cache, err := getCachedIP(testRecord, IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.IPAddress.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected 192.0.2.1, got %s", cache.IPAddress)
+	}
+}
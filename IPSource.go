@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A service or method used to find the device's public IP address.
+type IPSource interface {
+	// A short name used in the logs
+	Name() string
+	// Returns the public IP address for the IP version
+	GetIP(ctx context.Context, version IPVersion) (net.IP, error)
+}
+
+// The options for an IPSource in the config file.
+type IPSourceConfig struct {
+	// The type of source: "icanhazip", "ipify", "cloudflare", "url", "interface", "upnp", "natpmp", "pcp", "gateway", "stun" or "dns".
+	Type string `yaml:"Type"`
+	// The URL to query. Only used by the "url" type.
+	URL string `yaml:"URL"`
+	// How to read the response of a "url" source: "text" (default) or "json".
+	Format string `yaml:"Format"`
+	// The path to the address in a JSON response, separated by dots. e.g. "data.ip".
+	JSONPath string `yaml:"JSONPath"`
+	// The name of the network interface to read the address from. e.g. "ppp0". Only used by the "interface" type.
+	Interface string `yaml:"Interface"`
+	// The router's address for NAT-PMP and PCP. e.g. "192.168.1.1". Defaults to the default gateway (Linux only).
+	Gateway string `yaml:"Gateway"`
+	// The URL of the router's UPnP device description. If left empty, the router is discovered with SSDP.
+	Location string `yaml:"Location"`
+	// The servers to query, in order. host:port. Used by the "stun" and "dns" types.
+	Servers []string `yaml:"Servers"`
+	// The DNS query to use: "cloudflare" (whoami.cloudflare), "opendns" (myip.opendns.com) or "google" (o-o.myaddr.l.google.com). Used by the "dns" type.
+	Provider string `yaml:"Provider"`
+	// How long to wait for the source before trying the next one. Defaults to 10s.
+	Timeout time.Duration `yaml:"Timeout"`
+}
+
+const defaultIPSourceTimeout time.Duration = 10 * time.Second
+
+var (
+	noIPSourcesErr        = errors.New("no IP sources configured")
+	unknownIPSourceErr    = errors.New("unknown IP source type")
+	ipVersionMismatchErr  = errors.New("IP address does not match the IP version")
+	noConsensusErr        = errors.New("not enough IP sources agree on the address")
+	defaultIPSourceConfig = []IPSourceConfig{{Type: "icanhazip"}}
+)
+
+// A source and the options that apply to every type of source.
+type configuredIPSource struct {
+	IPSource
+	timeout time.Duration
+}
+
+// The sources to use for each IP version, in order. Set by setupIPSources.
+var ipSources = map[IPVersion][]configuredIPSource{}
+
+// The amount of sources that have to agree on the address for each IP version. 0 disables consensus mode.
+var ipSourcesQuorum = map[IPVersion]int{}
+
+// Creates the IP sources from the config file. Uses icanhazip if none are configured.
+func setupIPSources() error {
+	quorums := map[IPVersion]int{IPv4: conf.IPv4SourcesQuorum, IPv6: conf.IPv6SourcesQuorum}
+
+	for version, configs := range map[IPVersion][]IPSourceConfig{IPv4: conf.IPv4Sources, IPv6: conf.IPv6Sources} {
+		if len(configs) == 0 {
+			configs = defaultIPSourceConfig
+		}
+
+		sources := make([]configuredIPSource, 0, len(configs))
+		for i, sourceConf := range configs {
+			source, err := newIPSource(sourceConf, version)
+			if err != nil {
+				return fmt.Errorf("IP%s source %d: %w", version, i, err)
+			}
+
+			timeout := sourceConf.Timeout
+			if timeout <= 0 {
+				timeout = defaultIPSourceTimeout
+			}
+			sources = append(sources, configuredIPSource{IPSource: source, timeout: timeout})
+		}
+		ipSources[version] = sources
+
+		quorum := quorums[version]
+		if quorum < 0 || quorum > len(sources) {
+			return fmt.Errorf("IP%s quorum of %d with %d sources", version, quorum, len(sources))
+		}
+		// Otherwise two different addresses could both reach the quorum
+		if quorum > 0 && quorum <= len(sources)/2 {
+			return fmt.Errorf("IP%s quorum of %d with %d sources. It has to be more than half of the sources", version, quorum, len(sources))
+		}
+		ipSourcesQuorum[version] = quorum
+	}
+	return nil
+}
+
+// Creates the IPSource described by sourceConf.
+func newIPSource(sourceConf IPSourceConfig, version IPVersion) (IPSource, error) {
+	switch strings.ToLower(sourceConf.Type) {
+	case "icanhazip", "":
+		return newHTTPSource("icanhazip", "https://ip"+string(version)+".icanhazip.com", formatText, "", version), nil
+	case "ipify":
+		if version == IPv6 {
+			return newHTTPSource("ipify", "https://api6.ipify.org", formatText, "", version), nil
+		}
+		return newHTTPSource("ipify", "https://api.ipify.org", formatText, "", version), nil
+	case "cloudflare":
+		if version == IPv6 {
+			return newHTTPSource("cloudflare", "https://[2606:4700:4700::1111]/cdn-cgi/trace", formatTrace, "", version), nil
+		}
+		return newHTTPSource("cloudflare", "https://1.1.1.1/cdn-cgi/trace", formatTrace, "", version), nil
+	case "url":
+		if sourceConf.URL == "" {
+			return nil, errors.New("URL missing")
+		}
+
+		format := strings.ToLower(sourceConf.Format)
+		switch format {
+		case "", formatText:
+			format = formatText
+		case formatJSON:
+			if sourceConf.JSONPath == "" {
+				return nil, errors.New("JSONPath missing")
+			}
+		default:
+			return nil, fmt.Errorf("unknown format %q", sourceConf.Format)
+		}
+		return newHTTPSource(sourceConf.URL, sourceConf.URL, format, sourceConf.JSONPath, version), nil
+	case "interface":
+		if sourceConf.Interface == "" {
+			return nil, errors.New("Interface missing")
+		}
+		return &interfaceSource{name: sourceConf.Interface}, nil
+	case "upnp", "natpmp", "pcp", "gateway":
+		if version != IPv4 {
+			return nil, ipv4OnlySourceErr
+		}
+
+		upnp := newUPnPSource(sourceConf.Location)
+		natPMP := &natPMPSource{gateway: sourceConf.Gateway}
+		pcp := &pcpSource{gateway: sourceConf.Gateway}
+
+		switch strings.ToLower(sourceConf.Type) {
+		case "upnp":
+			return upnp, nil
+		case "natpmp":
+			return natPMP, nil
+		case "pcp":
+			return pcp, nil
+		}
+		// Ask the router with UPnP first, then NAT-PMP and PCP
+		return &fallbackSource{name: "gateway", sources: []IPSource{upnp, natPMP, pcp}}, nil
+	case "stun":
+		return &stunSource{servers: sourceConf.Servers}, nil
+	case "dns":
+		provider := strings.ToLower(sourceConf.Provider)
+		if provider == "" {
+			provider = "cloudflare"
+		}
+		if _, found := dnsProviders[provider]; !found {
+			return nil, fmt.Errorf("unknown DNS provider %q", sourceConf.Provider)
+		}
+		return &dnsSource{provider: provider, servers: sourceConf.Servers}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", unknownIPSourceErr, sourceConf.Type)
+	}
+}
+
+// An IPSource that tries several sources in order. They share the timeout.
+type fallbackSource struct {
+	name    string
+	sources []IPSource
+}
+
+func (s *fallbackSource) Name() string {
+	return s.name
+}
+
+func (s *fallbackSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	var errs []error
+	for _, source := range s.sources {
+		address, err := source.GetIP(ctx, version)
+		if err == nil {
+			return address, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source.Name(), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Gets the device's public IP address for the IP version.
+// The sources are tried in order until one of them returns a valid address.
+// In consensus mode, all of the sources are used instead (see getIPConsensus).
+func getIP(version IPVersion) (net.IP, error) {
+	sources := ipSources[version]
+	if len(sources) == 0 {
+		return nil, noIPSourcesErr
+	}
+
+	if quorum := ipSourcesQuorum[version]; quorum > 0 {
+		return getIPConsensus(version, sources, quorum)
+	}
+
+	var err error
+	for _, source := range sources {
+		var address net.IP
+		address, err = getIPFromSource(source, version)
+		if err == nil {
+			log.WithFields(log.Fields{"source": source.Name(), "version": version, "ip": address}).Debug("[getIP] Got IP address")
+			return address, nil
+		}
+		log.WithFields(log.Fields{"source": source.Name(), "version": version, "error": err}).Warn("[getIP] IP source failed")
+	}
+
+	return nil, fmt.Errorf("all IP%s sources failed. Last error: %w", version, err)
+}
+
+// Asks every source in parallel and returns the address that at least quorum of them agree on.
+// Protects against a single misbehaving or compromised source changing the records.
+func getIPConsensus(version IPVersion, sources []configuredIPSource, quorum int) (net.IP, error) {
+	type result struct {
+		source  string
+		address net.IP
+		err     error
+	}
+
+	results := make(chan result, len(sources))
+	for _, source := range sources {
+		go func(source configuredIPSource) {
+			address, err := getIPFromSource(source, version)
+			results <- result{source: source.Name(), address: address, err: err}
+		}(source)
+	}
+
+	votes := make(map[string]int)
+	answers := make(log.Fields)
+	for range sources {
+		r := <-results
+		if r.err != nil {
+			log.WithFields(log.Fields{"source": r.source, "version": version, "error": r.err}).Warn("[getIPConsensus] IP source failed")
+			answers[r.source] = r.err.Error()
+			continue
+		}
+		// String() normalizes the address so that different formats of the same address are counted together
+		votes[r.address.String()]++
+		answers[r.source] = r.address.String()
+	}
+
+	var winner string
+	for address, count := range votes {
+		if count > votes[winner] {
+			winner = address
+		}
+	}
+
+	if votes[winner] < quorum {
+		log.WithFields(answers).WithFields(log.Fields{"version": version, "quorum": quorum}).Error("[getIPConsensus] IP sources disagree")
+		return nil, fmt.Errorf("%w: %d of %d needed", noConsensusErr, votes[winner], quorum)
+	}
+
+	if len(votes) > 1 {
+		log.WithFields(answers).WithFields(log.Fields{"version": version, "ip": winner}).Warn("[getIPConsensus] Some IP sources disagree with the quorum")
+		notify(eventSourceDisagreement, nil, version, nil, net.ParseIP(winner), fmt.Errorf("some IP sources disagree with the quorum: %s", formatVotes(votes)))
+	}
+
+	return net.ParseIP(winner), nil
+}
+
+// Returns the addresses and how many sources returned each of them, e.g. "198.51.100.1 (2), 203.0.113.66 (1)".
+func formatVotes(votes map[string]int) string {
+	addresses := make([]string, 0, len(votes))
+	for address := range votes {
+		addresses = append(addresses, address)
+	}
+	// The most voted first
+	sort.Slice(addresses, func(i, j int) bool {
+		if votes[addresses[i]] != votes[addresses[j]] {
+			return votes[addresses[i]] > votes[addresses[j]]
+		}
+		return addresses[i] < addresses[j]
+	})
+
+	pairs := make([]string, len(addresses))
+	for i, address := range addresses {
+		pairs[i] = fmt.Sprintf("%s (%d)", address, votes[address])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// Gets the IP address from a single source and makes sure that it is valid for the IP version.
+func getIPFromSource(source configuredIPSource, version IPVersion) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(runContext, source.timeout)
+	defer cancel()
+
+	address, err := source.GetIP(ctx, version)
+	if err == nil && address == nil {
+		err = noIPAddressFoundErr
+	}
+	if err == nil && !version.matches(address) {
+		err = fmt.Errorf("%w: %s", ipVersionMismatchErr, address)
+	}
+
+	if err != nil {
+		// Stopping the run isn't a failure of the source
+		if !runAborted() {
+			ipSourceFailures.add(1, source.Name(), string(version))
+		}
+		return nil, err
+	}
+	return address, nil
+}
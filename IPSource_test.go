@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An IPSource that returns a fixed value. Used to test the fallback between sources.
+type staticSource struct {
+	address net.IP
+	err     error
+}
+
+func (s staticSource) Name() string {
+	return "static"
+}
+
+func (s staticSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	return s.address, s.err
+}
+
+func TestHTTPSourceFormats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/text":
+			w.Write([]byte("203.0.113.7\n"))
+		case "/json":
+			w.Write([]byte(`{"data": {"ip": "203.0.113.8"}}`))
+		case "/trace":
+			w.Write([]byte("fl=123\nh=1.1.1.1\nip=203.0.113.9\nts=1700000000\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		source   *httpSource
+		expected string
+	}{
+		{newHTTPSource("text", server.URL+"/text", formatText, "", IPv4), "203.0.113.7"},
+		{newHTTPSource("json", server.URL+"/json", formatJSON, "data.ip", IPv4), "203.0.113.8"},
+		{newHTTPSource("trace", server.URL+"/trace", formatTrace, "", IPv4), "203.0.113.9"},
+	}
+
+	for _, test := range tests {
+		address, err := test.source.GetIP(context.Background(), IPv4)
+		if err != nil {
+			t.Errorf("%s: %s", test.source.Name(), err)
+			continue
+		}
+		if !address.Equal(net.ParseIP(test.expected)) {
+			t.Errorf("%s: Expected %s, got %s", test.source.Name(), test.expected, address)
+		}
+	}
+
+	_, err := newHTTPSource("missing", server.URL+"/missing", formatText, "", IPv4).GetIP(context.Background(), IPv4)
+	if err == nil {
+		t.Error("Expected an error for a 404 response")
+	}
+}
+
+// The next source should be used when one fails or returns an invalid address
+func TestGetIPFallback(t *testing.T) {
+	defer delete(ipSources, IPv4)
+
+	ipSources[IPv4] = []configuredIPSource{
+		{IPSource: staticSource{err: errors.New("down")}, timeout: time.Second},
+		{IPSource: staticSource{address: net.ParseIP("2001:db8::1")}, timeout: time.Second},
+		{IPSource: staticSource{address: net.ParseIP("198.51.100.1")}, timeout: time.Second},
+	}
+
+	address, err := getIP(IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("Expected 198.51.100.1, got %s", address)
+	}
+
+	ipSources[IPv4] = ipSources[IPv4][:2]
+	if _, err := getIP(IPv4); err == nil {
+		t.Error("Expected an error when all sources fail")
+	}
+}
+
+func TestNewIPSourceInvalid(t *testing.T) {
+	if _, err := newIPSource(IPSourceConfig{Type: "carrier-pigeon"}, IPv4); !errors.Is(err, unknownIPSourceErr) {
+		t.Errorf("Expected unknownIPSourceErr, got %v", err)
+	}
+	if _, err := newIPSource(IPSourceConfig{Type: "url"}, IPv4); err == nil {
+		t.Error("Expected an error for a url source without URL")
+	}
+	if _, err := newIPSource(IPSourceConfig{Type: "url", URL: "https://example.com", Format: "json"}, IPv4); err == nil {
+		t.Error("Expected an error for a json source without JSONPath")
+	}
+}
+
+// The address should only be used when enough sources agree on it
+func TestGetIPConsensus(t *testing.T) {
+	defer delete(ipSources, IPv4)
+	defer delete(ipSourcesQuorum, IPv4)
+
+	ipSources[IPv4] = []configuredIPSource{
+		{IPSource: staticSource{address: net.ParseIP("198.51.100.1")}, timeout: time.Second},
+		{IPSource: staticSource{address: net.ParseIP("203.0.113.66")}, timeout: time.Second},
+		{IPSource: staticSource{address: net.ParseIP("198.51.100.1")}, timeout: time.Second},
+		{IPSource: staticSource{err: errors.New("down")}, timeout: time.Second},
+	}
+
+	ipSourcesQuorum[IPv4] = 2
+	address, err := getIP(IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("Expected 198.51.100.1, got %s", address)
+	}
+
+	ipSourcesQuorum[IPv4] = 3
+	if _, err := getIP(IPv4); !errors.Is(err, noConsensusErr) {
+		t.Errorf("Expected noConsensusErr, got %v", err)
+	}
+}
+
+func TestSetupIPSourcesQuorum(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		delete(ipSources, IPv4)
+		delete(ipSources, IPv6)
+		delete(ipSourcesQuorum, IPv4)
+		delete(ipSourcesQuorum, IPv6)
+	}()
+
+	sources := []IPSourceConfig{{Type: "icanhazip"}, {Type: "ipify"}, {Type: "cloudflare"}, {Type: "icanhazip"}}
+	for quorum, valid := range map[int]bool{0: true, 1: false, 2: false, 3: true, 4: true, 5: false} {
+		conf = Config{IPv4Sources: sources, IPv4SourcesQuorum: quorum}
+		if err := setupIPSources(); (err == nil) != valid {
+			t.Errorf("Quorum of %d with %d sources: expected valid to be %t, got %v", quorum, len(sources), valid, err)
+		}
+	}
+}
+
+// A disagreement that doesn't stop the quorum from being reached is still sent to the notifiers
+func TestGetIPConsensusNotifiesDisagreement(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		notifiers = nil
+		delete(ipSources, IPv4)
+		delete(ipSourcesQuorum, IPv4)
+	}()
+
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	conf = Config{Notifiers: []NotifierConfig{{URL: server.URL}}}
+	if err := setupNotifiers(); err != nil {
+		t.Fatal(err)
+	}
+
+	ipSources[IPv4] = []configuredIPSource{
+		{IPSource: staticSource{address: net.ParseIP("198.51.100.1")}, timeout: time.Second},
+		{IPSource: staticSource{address: net.ParseIP("198.51.100.1")}, timeout: time.Second},
+		{IPSource: staticSource{address: net.ParseIP("203.0.113.66")}, timeout: time.Second},
+	}
+	ipSourcesQuorum[IPv4] = 2
+
+	if _, err := getIP(IPv4); err != nil {
+		t.Fatal(err)
+	}
+	waitForNotifications(5 * time.Second)
+
+	if received.Event != eventSourceDisagreement || received.NewIP != "198.51.100.1" || !strings.HasSuffix(received.Error, "198.51.100.1 (2), 203.0.113.66 (1)") {
+		t.Errorf("Expected a source-disagreement notification, got %+v", received)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	// The address is in a range that can't be reached from the internet
+	nonPublicIPErr = errors.New("the address is not a public address")
+	// The address is in the shared address space used by carrier-grade NAT (RFC 6598)
+	cgnatIPErr = errors.New("the address is behind a carrier-grade NAT (CGNAT) and can't be reached from the internet")
+	// The address is in one of the DeniedIPRanges
+	deniedIPErr = errors.New("the address is in DeniedIPRanges")
+)
+
+// The ranges that are never published, with the reason they are not public.
+// https://www.iana.org/assignments/iana-ipv4-special-registry and https://www.iana.org/assignments/iana-ipv6-special-registry
+var reservedIPRanges = []struct {
+	cidr   string
+	reason string
+}{
+	{"0.0.0.0/8", "this network"},
+	{"10.0.0.0/8", "private"},
+	{"100.64.0.0/10", "CGNAT"},
+	{"127.0.0.0/8", "loopback"},
+	{"169.254.0.0/16", "link-local"},
+	{"172.16.0.0/12", "private"},
+	{"192.0.0.0/24", "IETF protocol assignments"},
+	{"192.0.2.0/24", "documentation"},
+	{"192.88.99.0/24", "6to4 relay anycast"},
+	{"192.168.0.0/16", "private"},
+	{"198.18.0.0/15", "benchmarking"},
+	{"198.51.100.0/24", "documentation"},
+	{"203.0.113.0/24", "documentation"},
+	{"224.0.0.0/4", "multicast"},
+	{"240.0.0.0/4", "reserved"},
+	{"::/127", "unspecified or loopback"},
+	{"64:ff9b::/96", "NAT64"},
+	{"64:ff9b:1::/48", "NAT64"},
+	{"100::/64", "discard-only"},
+	{"2001:db8::/32", "documentation"},
+	{"3fff::/20", "documentation"},
+	{"fc00::/7", "unique local"},
+	{"fe80::/10", "link-local"},
+	{"fec0::/10", "site-local"},
+	{"ff00::/8", "multicast"},
+}
+
+// A range of addresses and the reason they are not public.
+type ipRange struct {
+	network *net.IPNet
+	reason  string
+}
+
+var (
+	parsedReservedIPRanges []ipRange
+	// Addresses in these ranges are always published, even if they are reserved. Set from AllowedIPRanges.
+	allowedIPRanges []*net.IPNet
+	// Addresses in these ranges are never published. Set from DeniedIPRanges.
+	deniedIPRanges []*net.IPNet
+)
+
+func init() {
+	for _, reserved := range reservedIPRanges {
+		_, network, err := net.ParseCIDR(reserved.cidr)
+		if err != nil {
+			panic(err)
+		}
+		parsedReservedIPRanges = append(parsedReservedIPRanges, ipRange{network: network, reason: reserved.reason})
+	}
+}
+
+// Parses AllowedIPRanges and DeniedIPRanges from the config file.
+func setupIPValidation() error {
+	var err error
+	if allowedIPRanges, err = parseCIDRs(conf.AllowedIPRanges); err != nil {
+		return fmt.Errorf("AllowedIPRanges: %w", err)
+	}
+	if deniedIPRanges, err = parseCIDRs(conf.DeniedIPRanges); err != nil {
+		return fmt.Errorf("DeniedIPRanges: %w", err)
+	}
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Returns an error if the address shouldn't be published.
+// AllowedIPRanges are checked first, then DeniedIPRanges and then the reserved ranges.
+func validatePublicIP(address net.IP) error {
+	if containsIP(allowedIPRanges, address) {
+		return nil
+	}
+
+	if containsIP(deniedIPRanges, address) {
+		return fmt.Errorf("%w: %s", deniedIPErr, address)
+	}
+
+	for _, reserved := range parsedReservedIPRanges {
+		if !reserved.network.Contains(address) {
+			continue
+		}
+		if reserved.reason == "CGNAT" {
+			return fmt.Errorf("%w: %s is in %s", cgnatIPErr, address, reserved.network)
+		}
+		return fmt.Errorf("%w: %s is in %s (%s)", nonPublicIPErr, address, reserved.network, reserved.reason)
+	}
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, address net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
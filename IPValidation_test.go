@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestValidatePublicIP(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected error
+	}{
+		{"1.1.1.1", nil},
+		{"8.8.4.4", nil},
+		{"2606:4700:4700::1111", nil},
+		{"10.1.2.3", nonPublicIPErr},
+		{"172.20.0.1", nonPublicIPErr},
+		{"192.168.1.10", nonPublicIPErr},
+		{"127.0.0.1", nonPublicIPErr},
+		{"169.254.10.1", nonPublicIPErr},
+		{"192.0.2.1", nonPublicIPErr},
+		{"224.0.0.251", nonPublicIPErr},
+		{"255.255.255.255", nonPublicIPErr},
+		{"100.64.12.34", cgnatIPErr},
+		{"100.127.255.254", cgnatIPErr},
+		{"::1", nonPublicIPErr},
+		{"fe80::1", nonPublicIPErr},
+		{"fd00::1", nonPublicIPErr},
+		{"2001:db8::1", nonPublicIPErr},
+		{"ff02::1", nonPublicIPErr},
+		{"::ffff:1.1.1.1", nil}, // Parsed as an IPv4 address
+	}
+
+	for _, test := range tests {
+		err := validatePublicIP(net.ParseIP(test.address))
+		if !errors.Is(err, test.expected) || (test.expected == nil && err != nil) {
+			t.Errorf("validatePublicIP(%s) = %v, expected %v", test.address, err, test.expected)
+		}
+	}
+}
+
+func TestValidatePublicIPRanges(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		allowedIPRanges, deniedIPRanges = nil, nil
+	}()
+
+	conf = Config{AllowedIPRanges: []string{"100.64.0.0/16"}, DeniedIPRanges: []string{"1.1.1.0/24", "2606:4700::/32"}}
+	if err := setupIPValidation(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validatePublicIP(net.ParseIP("100.64.1.1")); err != nil {
+		t.Errorf("Expected an address in AllowedIPRanges to be valid, got %v", err)
+	}
+	if err := validatePublicIP(net.ParseIP("100.65.1.1")); !errors.Is(err, cgnatIPErr) {
+		t.Errorf("Expected cgnatIPErr outside of AllowedIPRanges, got %v", err)
+	}
+	if err := validatePublicIP(net.ParseIP("1.1.1.1")); !errors.Is(err, deniedIPErr) {
+		t.Errorf("Expected deniedIPErr, got %v", err)
+	}
+	if err := validatePublicIP(net.ParseIP("2606:4700::1111")); !errors.Is(err, deniedIPErr) {
+		t.Errorf("Expected deniedIPErr, got %v", err)
+	}
+
+	conf = Config{DeniedIPRanges: []string{"1.1.1.1"}}
+	if err := setupIPValidation(); err == nil {
+		t.Error("Expected an error for a range without a prefix length")
+	}
+}
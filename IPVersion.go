@@ -1,5 +1,7 @@
 package main
 
+import "net"
+
 // An IP Version
 //
 // The value is used to form the URL to get the device's public IP.
@@ -20,3 +22,15 @@ func (version IPVersion) getRecordType() string {
 		return ""
 	}
 }
+
+// Returns true if the IP address belongs to the IP version.
+func (version IPVersion) matches(address net.IP) bool {
+	switch version {
+	case IPv4:
+		return address.To4() != nil
+	case IPv6:
+		return address.To4() == nil && address.To16() != nil
+	default:
+		return false
+	}
+}
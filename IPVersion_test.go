@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net"
 	"testing"
 )
 
@@ -15,3 +16,12 @@ func TestGetRecordType(t *testing.T) {
 		t.Error("Expected empty string for invalid version")
 	}
 }
+
+func TestVersionMatches(t *testing.T) {
+	if !IPv4.matches(net.ParseIP("192.0.2.1")) || IPv4.matches(net.ParseIP("2001:db8::1")) {
+		t.Error("IPv4 matched the wrong addresses")
+	}
+	if !IPv6.matches(net.ParseIP("2001:db8::1")) || IPv6.matches(net.ParseIP("192.0.2.1")) {
+		t.Error("IPv6 matched the wrong addresses")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "net"
+
+const defaultIPv6PrefixLength int = 64
+
+// Returns the record's IPv6 prefix length, falling back to the global IPv6PrefixLength and then to 64.
+func (r *Record) ipv6PrefixLength() int {
+	if r.IPv6PrefixLength != 0 {
+		return r.IPv6PrefixLength
+	}
+	if conf.IPv6PrefixLength != 0 {
+		return conf.IPv6PrefixLength
+	}
+	return defaultIPv6PrefixLength
+}
+
+// Returns the address that the record should point to for the device's address IP.
+// Records with an IPv6Suffix get the prefix of IP combined with the suffix, other records get IP itself.
+func (r *Record) addressFor(version IPVersion, IP net.IP) net.IP {
+	if version != IPv6 || r.IPv6Suffix == "" {
+		return IP
+	}
+	return combinePrefix(IP, r.ipv6PrefixLength(), net.ParseIP(r.IPv6Suffix))
+}
+
+// Returns an IPv6 address with the first prefixLength bits of prefix and the rest of the bits of suffix.
+func combinePrefix(prefix net.IP, prefixLength int, suffix net.IP) net.IP {
+	prefix, suffix = prefix.To16(), suffix.To16()
+	if prefix == nil || suffix == nil {
+		return nil
+	}
+
+	mask := net.CIDRMask(prefixLength, 128)
+	address := make(net.IP, net.IPv6len)
+	for i := range address {
+		address[i] = prefix[i]&mask[i] | suffix[i]&^mask[i]
+	}
+	return address
+}
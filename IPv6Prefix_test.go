@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCombinePrefix(t *testing.T) {
+	tests := []struct {
+		prefix       string
+		prefixLength int
+		suffix       string
+		expected     string
+	}{
+		{"2001:db8:aaaa:bb00::1", 64, "::2e0:4cff:fe68:1234", "2001:db8:aaaa:bb00:2e0:4cff:fe68:1234"},
+		// The subnet ID in the suffix is used when the delegated prefix is shorter than 64
+		{"2001:db8:aaaa:bb00::1", 56, "::1:2e0:4cff:fe68:1234", "2001:db8:aaaa:bb01:2e0:4cff:fe68:1234"},
+		// The bits of the suffix that are in the prefix are ignored
+		{"2001:db8:cccc:dd00::5", 56, "2001:db8:aaaa:bb01::10", "2001:db8:cccc:dd01::10"},
+		{"2001:db8::1", 128, "::2", "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		got := combinePrefix(net.ParseIP(test.prefix), test.prefixLength, net.ParseIP(test.suffix))
+		if !got.Equal(net.ParseIP(test.expected)) {
+			t.Errorf("combinePrefix(%s, %d, %s) = %s, expected %s", test.prefix, test.prefixLength, test.suffix, got, test.expected)
+		}
+	}
+}
+
+func TestAddressFor(t *testing.T) {
+	defer func() { conf = Config{} }()
+	conf = Config{IPv6PrefixLength: 56}
+
+	detected := net.ParseIP("2001:db8:aaaa:bb00::1")
+	nas := &Record{IPv6Suffix: "::1:2e0:4cff:fe68:1234"}
+	if got := nas.addressFor(IPv6, detected); !got.Equal(net.ParseIP("2001:db8:aaaa:bb01:2e0:4cff:fe68:1234")) {
+		t.Errorf("Expected the suffix to be combined with the detected prefix, got %s", got)
+	}
+
+	// Records without a suffix, and IPv4, use the detected address
+	if got := (&Record{}).addressFor(IPv6, detected); !got.Equal(detected) {
+		t.Errorf("Expected the detected address, got %s", got)
+	}
+	ipv4 := net.ParseIP("192.0.2.1")
+	if got := nas.addressFor(IPv4, ipv4); !got.Equal(ipv4) {
+		t.Errorf("Expected the detected IPv4 address, got %s", got)
+	}
+}
+
+// Records with a suffix only manage their AAAA record unless A is listed
+func TestManagesWithSuffix(t *testing.T) {
+	nas := &Record{IPv6Suffix: "::20"}
+	if nas.manages(IPv4) || !nas.manages(IPv6) {
+		t.Error("Expected a record with a suffix to only manage AAAA by default")
+	}
+
+	nas.RecordTypes = []string{"A", "AAAA"}
+	if !nas.manages(IPv4) {
+		t.Error("Expected the A record to be managed when it is listed")
+	}
+
+	if home := (&Record{}); !home.manages(IPv4) || !home.manages(IPv6) {
+		t.Error("Expected a record without a suffix to manage both types")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+)
+
+var noUsableInterfaceAddressErr = errors.New("no usable address found in the interface")
+
+// An address assigned to a network interface and the flags reported by the kernel (when available).
+type interfaceAddress struct {
+	address net.IP
+	// A temporary privacy address (RFC 8981). It changes often, so it shouldn't be published.
+	temporary bool
+	// The address' preferred lifetime expired. It is being replaced.
+	deprecated bool
+	// Duplicate Address Detection has not finished or it failed.
+	tentative bool
+}
+
+// An IPSource that reads the address from a local network interface. e.g. "ppp0" or "eth0".
+// It avoids sending requests to a third party when the public address is assigned to the device itself.
+type interfaceSource struct {
+	name string
+}
+
+func (s *interfaceSource) Name() string {
+	return "interface " + s.name
+}
+
+func (s *interfaceSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	addresses, err := getInterfaceAddresses(s.name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the addresses of %s: %w", s.name, err)
+	}
+
+	address := selectInterfaceAddress(addresses, version)
+	if address == nil {
+		return nil, noUsableInterfaceAddressErr
+	}
+	return address, nil
+}
+
+// Returns the addresses of the interface without the flags. Used when the platform doesn't provide them.
+func getInterfaceAddressesFromNet(name string) ([]interfaceAddress, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]interfaceAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			addresses = append(addresses, interfaceAddress{address: ipNet.IP})
+		}
+	}
+	return addresses, nil
+}
+
+// Picks the address to publish from the interface's addresses.
+// Link-local, ULA, deprecated, temporary and tentative addresses are skipped.
+// When there are several global addresses, EUI-64 (IPv6) and public (IPv4) addresses are preferred. Otherwise the kernel's order is kept.
+func selectInterfaceAddress(addresses []interfaceAddress, version IPVersion) net.IP {
+	candidates := make([]net.IP, 0, len(addresses))
+	for _, addr := range addresses {
+		if !version.matches(addr.address) || !addr.address.IsGlobalUnicast() {
+			continue
+		}
+
+		// fc00::/7 Unique Local Addresses are not reachable from the internet
+		if version == IPv6 && addr.address.IsPrivate() {
+			continue
+		}
+
+		if addr.temporary || addr.deprecated || addr.tentative {
+			continue
+		}
+		candidates = append(candidates, addr.address)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return interfaceAddressScore(candidates[i]) > interfaceAddressScore(candidates[j])
+	})
+	return candidates[0]
+}
+
+// Higher is better.
+func interfaceAddressScore(address net.IP) int {
+	if address.To4() != nil {
+		if address.IsPrivate() {
+			return 0
+		}
+		return 1
+	}
+
+	if isEUI64(address) {
+		return 1
+	}
+	return 0
+}
+
+// Returns true if the interface identifier of the IPv6 address was made from a MAC address (modified EUI-64), which is stable.
+func isEUI64(address net.IP) bool {
+	address = address.To16()
+	return address != nil && address[11] == 0xff && address[12] == 0xfe
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IPv6 address flags from linux/if_addr.h
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDADFailed  = 0x08
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// Returns the addresses of the interface. The IPv6 flags are read from /proc/net/if_inet6.
+func getInterfaceAddresses(name string) ([]interfaceAddress, error) {
+	addresses, err := getInterfaceAddressesFromNet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := readIfInet6("/proc/net/if_inet6", name)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "interface": name}).Debug("[getInterfaceAddresses] Failed to read IPv6 address flags")
+		return addresses, nil
+	}
+
+	for i, addr := range addresses {
+		if flag, found := flags[addr.address.String()]; found {
+			addresses[i].temporary = flag&ifaFlagTemporary != 0
+			addresses[i].deprecated = flag&ifaFlagDeprecated != 0
+			addresses[i].tentative = flag&(ifaFlagTentative|ifaFlagDADFailed) != 0
+		}
+	}
+	return addresses, nil
+}
+
+// Reads the flags of the interface's IPv6 addresses. Each line has the format:
+// <address in hex> <ifindex> <prefix length> <scope> <flags> <interface name>
+func readIfInet6(path string, name string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	flags := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[5] != name {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != net.IPv6len {
+			continue
+		}
+
+		flag, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		flags[net.IP(raw).String()] = flag
+	}
+	return flags, scanner.Err()
+}
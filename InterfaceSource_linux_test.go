@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadIfInet6(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "if_inet6")
+	data := `fe800000000000000000000000000001 02 40 20 80     eth0
+20010db8000000000000000000001234 02 40 00 01     eth0
+20010db8000000000000000000005678 02 40 00 80     eth0
+20010db8000000000000000000009999 03 40 00 80     wlan0
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err := readIfInet6(path, "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(flags) != 3 {
+		t.Errorf("Expected 3 addresses for eth0, got %d", len(flags))
+	}
+	if flags["2001:db8::1234"]&ifaFlagTemporary == 0 {
+		t.Error("Expected 2001:db8::1234 to be temporary")
+	}
+	if flags["2001:db8::5678"]&ifaFlagTemporary != 0 {
+		t.Error("Expected 2001:db8::5678 to not be temporary")
+	}
+}
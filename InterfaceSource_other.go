@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// Returns the addresses of the interface. The address flags are not available on this platform.
+func getInterfaceAddresses(name string) ([]interfaceAddress, error) {
+	return getInterfaceAddressesFromNet(name)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSelectInterfaceAddressIPv6(t *testing.T) {
+	addresses := []interfaceAddress{
+		{address: net.ParseIP("fe80::1")}, // link-local
+		{address: net.ParseIP("fd00::2")}, // ULA
+		{address: net.ParseIP("2001:db8::aaaa:bbbb:cccc:dddd"), temporary: true},
+		{address: net.ParseIP("2001:db8::1234"), deprecated: true},
+		{address: net.ParseIP("2001:db8::5678")},               // stable
+		{address: net.ParseIP("2001:db8::2e0:4cff:fe68:1234")}, // EUI-64
+		{address: net.ParseIP("192.0.2.1")},
+	}
+
+	address := selectInterfaceAddress(addresses, IPv6)
+	if !address.Equal(net.ParseIP("2001:db8::2e0:4cff:fe68:1234")) {
+		t.Errorf("Expected the EUI-64 address, got %s", address)
+	}
+
+	// Without the EUI-64 address, the stable one is used
+	address = selectInterfaceAddress(addresses[:5], IPv6)
+	if !address.Equal(net.ParseIP("2001:db8::5678")) {
+		t.Errorf("Expected 2001:db8::5678, got %s", address)
+	}
+
+	if selectInterfaceAddress(addresses[:4], IPv6) != nil {
+		t.Error("Expected no address when none are usable")
+	}
+}
+
+func TestSelectInterfaceAddressIPv4(t *testing.T) {
+	addresses := []interfaceAddress{
+		{address: net.ParseIP("169.254.1.1")},
+		{address: net.ParseIP("192.168.1.10")},
+		{address: net.ParseIP("198.51.100.20")},
+		{address: net.ParseIP("2001:db8::1")},
+	}
+
+	address := selectInterfaceAddress(addresses, IPv4)
+	if !address.Equal(net.ParseIP("198.51.100.20")) {
+		t.Errorf("Expected the public address, got %s", address)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// The port used by NAT-PMP and PCP servers
+	natPMPPort int = 5351
+	// How long to wait for the first answer. Doubled on every retry (RFC 6886 3.1).
+	natPMPInitialTimeout time.Duration = 250 * time.Millisecond
+	// How many times to send a request before giving up. Lower than RFC 6886's 9 so that other sources can be tried.
+	natPMPMaxAttempts int = 4
+
+	pcpVersion   byte = 2
+	pcpOpcodeMAP byte = 1
+	// The protocol number of UDP, used for the temporary mapping
+	pcpProtocolUDP byte = 17
+)
+
+var (
+	noGatewayErr          = errors.New("no gateway configured or found")
+	noGatewayAnswerErr    = errors.New("the gateway did not answer")
+	invalidGatewayRespErr = errors.New("invalid response from the gateway")
+)
+
+// An IPSource that asks the router for its external address using NAT-PMP (RFC 6886).
+type natPMPSource struct {
+	// The gateway's address. If it doesn't have a port, natPMPPort is used. If empty, the default gateway is used.
+	gateway string
+}
+
+// An IPSource that asks the router for its external address using PCP (RFC 6887).
+// PCP has no request to only get the address, so a short-lived UDP mapping is created and then deleted.
+type pcpSource struct {
+	// The gateway's address. If it doesn't have a port, natPMPPort is used. If empty, the default gateway is used.
+	gateway string
+}
+
+func (s *natPMPSource) Name() string {
+	return "natpmp"
+}
+
+func (s *natPMPSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	if version != IPv4 {
+		return nil, ipv4OnlySourceErr
+	}
+
+	conn, err := dialGateway(s.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Version 0, opcode 0: external address request
+	resp, err := exchangeWithGateway(ctx, conn, []byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp[0] != 0 || resp[1] != 128 {
+		return nil, invalidGatewayRespErr
+	}
+
+	if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+		return nil, fmt.Errorf("NAT-PMP result code %d", result)
+	}
+
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (s *pcpSource) Name() string {
+	return "pcp"
+}
+
+func (s *pcpSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	if version != IPv4 {
+		return nil, ipv4OnlySourceErr
+	}
+
+	conn, err := dialGateway(s.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	local := conn.LocalAddr().(*net.UDPAddr)
+	resp, err := exchangeWithGateway(ctx, conn, buildPCPMapRequest(local, nonce, 60), 60)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := parsePCPMapResponse(resp, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete the mapping. Nothing to do if it fails, it expires on its own.
+	conn.Write(buildPCPMapRequest(local, nonce, 0))
+
+	return address, nil
+}
+
+// Builds a PCP MAP request for the local UDP port with the lifetime in seconds. 0 deletes the mapping.
+func buildPCPMapRequest(local *net.UDPAddr, nonce []byte, lifetime uint32) []byte {
+	req := make([]byte, 60)
+	// Common header
+	req[0] = pcpVersion
+	req[1] = pcpOpcodeMAP
+	binary.BigEndian.PutUint32(req[4:8], lifetime)
+	copy(req[8:24], local.IP.To16())
+	// MAP opcode
+	copy(req[24:36], nonce)
+	req[36] = pcpProtocolUDP
+	binary.BigEndian.PutUint16(req[40:42], uint16(local.Port))
+	// Suggested external port and address are left empty
+	copy(req[44:60], net.IPv4zero.To16())
+	return req
+}
+
+// Returns the assigned external address in a PCP MAP response.
+func parsePCPMapResponse(resp []byte, nonce []byte) (net.IP, error) {
+	if len(resp) < 60 || resp[0] != pcpVersion || resp[1] != 0x80|pcpOpcodeMAP {
+		return nil, invalidGatewayRespErr
+	}
+
+	if result := resp[3]; result != 0 {
+		return nil, fmt.Errorf("PCP result code %d", result)
+	}
+
+	if string(resp[24:36]) != string(nonce) {
+		return nil, invalidGatewayRespErr
+	}
+
+	return net.IP(resp[44:60]).To16(), nil
+}
+
+// Opens a UDP "connection" to the gateway's NAT-PMP/PCP port.
+func dialGateway(gateway string) (*net.UDPConn, error) {
+	if gateway == "" {
+		defaultGateway, err := getDefaultGateway()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", noGatewayErr, err)
+		}
+		gateway = defaultGateway.String()
+	}
+
+	if _, _, err := net.SplitHostPort(gateway); err != nil {
+		gateway = net.JoinHostPort(gateway, strconv.Itoa(natPMPPort))
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.DialUDP("udp4", nil, addr)
+}
+
+// Sends req to the gateway and waits for a response of at least minLength bytes, retrying with a doubling timeout.
+func exchangeWithGateway(ctx context.Context, conn *net.UDPConn, req []byte, minLength int) ([]byte, error) {
+	buf := make([]byte, 1100)
+	timeout := natPMPInitialTimeout
+
+	for attempt := 1; ; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+
+		n, err := conn.Read(buf)
+		if err == nil && n >= minLength {
+			return buf[:n], nil
+		}
+
+		if err != nil && !isTimeout(err) {
+			return nil, err
+		}
+
+		if ctx.Err() != nil || attempt >= natPMPMaxAttempts {
+			return nil, noGatewayAnswerErr
+		}
+
+		timeout *= 2
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Starts a fake NAT-PMP/PCP server that answers with external as the external address
+func newFakeGateway(t *testing.T, external net.IP) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			req := buf[:n]
+			switch {
+			case n == 2 && req[0] == 0 && req[1] == 0:
+				// NAT-PMP external address response
+				resp := make([]byte, 12)
+				resp[1] = 128
+				binary.BigEndian.PutUint32(resp[4:8], 1234)
+				copy(resp[8:12], external.To4())
+				conn.WriteTo(resp, addr)
+			case n == 60 && req[0] == pcpVersion && req[1] == pcpOpcodeMAP:
+				// PCP MAP response
+				resp := make([]byte, 60)
+				resp[0] = pcpVersion
+				resp[1] = 0x80 | pcpOpcodeMAP
+				copy(resp[4:8], req[4:8])
+				copy(resp[24:44], req[24:44])
+				copy(resp[44:60], external.To16())
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestNATPMPSource(t *testing.T) {
+	gateway := newFakeGateway(t, net.ParseIP("203.0.113.5"))
+	defer gateway.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	source := &natPMPSource{gateway: gateway.LocalAddr().String()}
+	address, err := source.GetIP(ctx, IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("203.0.113.5")) {
+		t.Errorf("Expected 203.0.113.5, got %s", address)
+	}
+}
+
+func TestPCPSource(t *testing.T) {
+	gateway := newFakeGateway(t, net.ParseIP("203.0.113.6"))
+	defer gateway.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	source := &pcpSource{gateway: gateway.LocalAddr().String()}
+	address, err := source.GetIP(ctx, IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("203.0.113.6")) {
+		t.Errorf("Expected 203.0.113.6, got %s", address)
+	}
+}
+
+// A gateway that doesn't answer should fail instead of waiting forever
+func TestNATPMPSourceNoAnswer(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	source := &natPMPSource{gateway: conn.LocalAddr().String()}
+	if _, err := source.GetIP(ctx, IPv4); err == nil {
+		t.Error("Expected an error when the gateway doesn't answer")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	stunMagicCookie     uint32 = 0x2112A442
+	stunBindingRequest  uint16 = 0x0001
+	stunBindingResponse uint16 = 0x0101
+	stunHeaderLength    int    = 20
+
+	stunAttrMappedAddress    uint16 = 0x0001
+	stunAttrXORMappedAddress uint16 = 0x0020
+
+	stunFamilyIPv4 byte = 0x01
+	stunFamilyIPv6 byte = 0x02
+
+	stunDefaultPort string = "3478"
+	// How long to wait for an answer before sending the request again. Doubled on every retry (RFC 5389 7.2.1).
+	stunInitialTimeout time.Duration = 500 * time.Millisecond
+	stunMaxAttempts    int           = 3
+)
+
+var (
+	defaultSTUNServers     = []string{"stun.cloudflare.com:3478", "stun.l.google.com:19302"}
+	noSTUNAnswerErr        = errors.New("the STUN server did not answer")
+	invalidSTUNResponseErr = errors.New("invalid STUN response")
+	noMappedAddressErr     = errors.New("no mapped address in the STUN response")
+)
+
+// An IPSource that gets the address with a STUN (RFC 5389) Binding Request.
+// Useful on networks where outbound HTTP is blocked but UDP works.
+type stunSource struct {
+	// The STUN servers to try in order. host:port, the port defaults to 3478.
+	servers []string
+}
+
+func (s *stunSource) Name() string {
+	return "stun"
+}
+
+func (s *stunSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	servers := s.servers
+	if len(servers) == 0 {
+		servers = defaultSTUNServers
+	}
+
+	var errs []error
+	for _, server := range servers {
+		address, err := stunBinding(ctx, server, version)
+		if err == nil {
+			return address, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Sends a Binding Request to the server over the IP version and returns the mapped address.
+func stunBinding(ctx context.Context, server string, version IPVersion) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, stunDefaultPort)
+	}
+
+	network := "udp4"
+	if version == IPv6 {
+		network = "udp6"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	transactionID := make([]byte, 12)
+	if _, err := rand.Read(transactionID); err != nil {
+		return nil, err
+	}
+	req := buildSTUNBindingRequest(transactionID)
+
+	buf := make([]byte, 1500)
+	timeout := stunInitialTimeout
+	for attempt := 1; attempt <= stunMaxAttempts; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if !isTimeout(err) {
+					return nil, err
+				}
+				break
+			}
+
+			address, err := parseSTUNBindingResponse(buf[:n], transactionID)
+			// Ignore answers to other requests
+			if errors.Is(err, invalidSTUNResponseErr) {
+				continue
+			}
+			return address, err
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+		timeout *= 2
+	}
+	return nil, noSTUNAnswerErr
+}
+
+func buildSTUNBindingRequest(transactionID []byte) []byte {
+	req := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	// No attributes, the length stays 0
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	copy(req[8:20], transactionID)
+	return req
+}
+
+// Returns the address in the XOR-MAPPED-ADDRESS attribute, or the MAPPED-ADDRESS attribute for old servers.
+func parseSTUNBindingResponse(resp []byte, transactionID []byte) (net.IP, error) {
+	if len(resp) < stunHeaderLength ||
+		binary.BigEndian.Uint16(resp[0:2]) != stunBindingResponse ||
+		binary.BigEndian.Uint32(resp[4:8]) != stunMagicCookie ||
+		!bytes.Equal(resp[8:20], transactionID) {
+		return nil, invalidSTUNResponseErr
+	}
+
+	length := int(binary.BigEndian.Uint16(resp[2:4]))
+	if stunHeaderLength+length > len(resp) {
+		return nil, invalidSTUNResponseErr
+	}
+
+	var mapped net.IP
+	attrs := resp[stunHeaderLength : stunHeaderLength+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLength > len(attrs) {
+			return nil, invalidSTUNResponseErr
+		}
+		value := attrs[4 : 4+attrLength]
+
+		switch attrType {
+		case stunAttrXORMappedAddress:
+			return parseSTUNAddress(value, resp[4:20])
+		case stunAttrMappedAddress:
+			mapped, _ = parseSTUNAddress(value, nil)
+		}
+
+		// Attributes are padded to 4 bytes
+		next := 4 + (attrLength+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, noMappedAddressErr
+}
+
+// Parses a (XOR-)MAPPED-ADDRESS value. xorKey is the magic cookie followed by the transaction ID, or nil if the address is not XORed.
+func parseSTUNAddress(value []byte, xorKey []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, invalidSTUNResponseErr
+	}
+
+	var size int
+	switch value[1] {
+	case stunFamilyIPv4:
+		size = net.IPv4len
+	case stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil, invalidSTUNResponseErr
+	}
+
+	if len(value) < 4+size {
+		return nil, invalidSTUNResponseErr
+	}
+
+	address := make(net.IP, size)
+	copy(address, value[4:4+size])
+	if xorKey != nil {
+		for i := range address {
+			address[i] ^= xorKey[i]
+		}
+	}
+	return address, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Starts a fake STUN server that answers with a XOR-MAPPED-ADDRESS for mapped
+func newFakeSTUNServer(t *testing.T, network, address string, mapped net.IP) net.PacketConn {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skipf("Can't listen on %s: %s", address, err)
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < stunHeaderLength || binary.BigEndian.Uint16(buf[0:2]) != stunBindingRequest {
+				continue
+			}
+
+			family, ip := stunFamilyIPv4, mapped.To4()
+			if ip == nil {
+				family, ip = stunFamilyIPv6, mapped.To16()
+			}
+
+			// An unknown attribute before the address to test skipping attributes
+			attrs := []byte{0x80, 0x22, 0x00, 0x03, 'g', 'o', '!', 0x00}
+
+			value := make([]byte, 4+len(ip))
+			value[1] = family
+			binary.BigEndian.PutUint16(value[2:4], 1234^uint16(stunMagicCookie>>16))
+			for i := range ip {
+				value[4+i] = ip[i] ^ buf[4+i]
+			}
+			attr := make([]byte, 4)
+			binary.BigEndian.PutUint16(attr[0:2], stunAttrXORMappedAddress)
+			binary.BigEndian.PutUint16(attr[2:4], uint16(len(value)))
+			attrs = append(attrs, append(attr, value...)...)
+
+			resp := make([]byte, stunHeaderLength)
+			binary.BigEndian.PutUint16(resp[0:2], stunBindingResponse)
+			binary.BigEndian.PutUint16(resp[2:4], uint16(len(attrs)))
+			copy(resp[4:20], buf[4:20])
+			conn.WriteTo(append(resp, attrs...), addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestSTUNSourceIPv4(t *testing.T) {
+	server := newFakeSTUNServer(t, "udp4", "127.0.0.1:0", net.ParseIP("198.51.100.77"))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	source := &stunSource{servers: []string{server.LocalAddr().String()}}
+	address, err := source.GetIP(ctx, IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("198.51.100.77")) {
+		t.Errorf("Expected 198.51.100.77, got %s", address)
+	}
+}
+
+func TestSTUNSourceIPv6(t *testing.T) {
+	server := newFakeSTUNServer(t, "udp6", "[::1]:0", net.ParseIP("2001:db8::77"))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	source := &stunSource{servers: []string{server.LocalAddr().String()}}
+	address, err := source.GetIP(ctx, IPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("2001:db8::77")) {
+		t.Errorf("Expected 2001:db8::77, got %s", address)
+	}
+}
+
+// Responses to other transactions should be ignored
+func TestParseSTUNBindingResponseWrongTransaction(t *testing.T) {
+	resp := buildSTUNBindingRequest(make([]byte, 12))
+	binary.BigEndian.PutUint16(resp[0:2], stunBindingResponse)
+
+	other := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	if _, err := parseSTUNBindingResponse(resp, other); err != invalidSTUNResponseErr {
+		t.Errorf("Expected invalidSTUNResponseErr, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpMulticastAddress string = "239.255.255.250:1900"
+	igdSearchTarget      string = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	// How long to wait for SSDP answers. Devices answer within MX (2) seconds.
+	ssdpTimeout time.Duration = 3 * time.Second
+)
+
+var (
+	noIGDFoundErr           = errors.New("no UPnP Internet Gateway Device found")
+	noWANConnectionFoundErr = errors.New("the UPnP device has no WANIPConnection or WANPPPConnection service")
+	ipv4OnlySourceErr       = errors.New("this IP source only supports IPv4")
+	noExternalIPInSOAPErr   = errors.New("no NewExternalIPAddress in the SOAP response")
+)
+
+// An IPSource that asks the router for its external address using UPnP IGD.
+// The router is discovered with SSDP unless the location of its device description is set.
+type upnpSource struct {
+	// The URL of the device description. If empty, it is discovered with SSDP.
+	location string
+	// Where the SSDP M-SEARCH is sent. The multicast address, but can be changed for tests.
+	ssdpAddress string
+	client      *http.Client
+}
+
+// A device in the UPnP device description. Devices can be nested.
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []upnpService `xml:"serviceList>service"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+func newUPnPSource(location string) *upnpSource {
+	return &upnpSource{location: location, ssdpAddress: ssdpMulticastAddress, client: &http.Client{}}
+}
+
+func (s *upnpSource) Name() string {
+	return "upnp"
+}
+
+func (s *upnpSource) GetIP(ctx context.Context, version IPVersion) (net.IP, error) {
+	if version != IPv4 {
+		return nil, ipv4OnlySourceErr
+	}
+
+	location := s.location
+	if location == "" {
+		var err error
+		location, err = s.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serviceType, controlURL, err := s.getWANConnection(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getExternalIPAddress(ctx, serviceType, controlURL)
+}
+
+// Sends an SSDP M-SEARCH for an Internet Gateway Device and returns the location of the first one that answers.
+func (s *upnpSource) discover(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", fmt.Errorf("Failed to open SSDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(ssdpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	addr, err := net.ResolveUDPAddr("udp4", s.ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpMulticastAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + igdSearchTarget + "\r\n\r\n"
+
+	if _, err := conn.WriteTo([]byte(search), addr); err != nil {
+		return "", fmt.Errorf("Failed to send SSDP search: %w", err)
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if isTimeout(err) {
+				return "", noIGDFoundErr
+			}
+			return "", err
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// Gets the device description and returns the type and absolute control URL of the WAN connection service.
+func (s *upnpSource) getWANConnection(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get the device description: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var root upnpRoot
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&root); err != nil {
+		return "", "", fmt.Errorf("Failed to decode the device description: %w", err)
+	}
+
+	service := findWANConnection(root.Device)
+	if service == nil {
+		return "", "", noWANConnectionFoundErr
+	}
+
+	base := location
+	if root.URLBase != "" {
+		base = root.URLBase
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", "", err
+	}
+
+	controlURL, err := baseURL.Parse(service.ControlURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	return service.ServiceType, controlURL.String(), nil
+}
+
+// Searches the device and its children for a WANIPConnection or WANPPPConnection service.
+func findWANConnection(device upnpDevice) *upnpService {
+	for i, service := range device.Services {
+		if strings.Contains(service.ServiceType, ":WANIPConnection:") || strings.Contains(service.ServiceType, ":WANPPPConnection:") {
+			return &device.Services[i]
+		}
+	}
+
+	for _, child := range device.Devices {
+		if service := findWANConnection(child); service != nil {
+			return service
+		}
+	}
+	return nil
+}
+
+// Calls the GetExternalIPAddress SOAP action.
+func (s *upnpSource) getExternalIPAddress(ctx context.Context, serviceType, controlURL string) (net.IP, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"></u:GetExternalIPAddress></s:Body>` +
+		`</s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, "POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call GetExternalIPAddress: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetExternalIPAddress failed with status code %d", resp.StatusCode)
+	}
+
+	decoder := xml.NewDecoder(io.LimitReader(resp.Body, 64*1024))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, noExternalIPInSOAPErr
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "NewExternalIPAddress" {
+			var addrStr string
+			if err := decoder.DecodeElement(&addrStr, &start); err != nil {
+				return nil, err
+			}
+
+			address := net.ParseIP(strings.TrimSpace(addrStr))
+			if address == nil {
+				return nil, invalidIPAddressErr
+			}
+			return address, nil
+		}
+	}
+}
+
+// Returns true if err is a network timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
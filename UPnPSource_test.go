@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDeviceDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const testSOAPResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+      <NewExternalIPAddress>198.51.100.42</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>`
+
+// Starts a fake Internet Gateway Device
+func newFakeIGD(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			w.Write([]byte(testDeviceDescription))
+		case "/ctl/IPConn":
+			body, _ := io.ReadAll(r.Body)
+			if r.Method != "POST" || !strings.Contains(r.Header.Get("SOAPAction"), "#GetExternalIPAddress") || !strings.Contains(string(body), "GetExternalIPAddress") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(testSOAPResponse))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestUPnPSourceWithLocation(t *testing.T) {
+	igd := newFakeIGD(t)
+	defer igd.Close()
+
+	source := newUPnPSource(igd.URL + "/rootDesc.xml")
+	address, err := source.GetIP(context.Background(), IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("198.51.100.42")) {
+		t.Errorf("Expected 198.51.100.42, got %s", address)
+	}
+}
+
+// The IGD should be found with SSDP when there is no location
+func TestUPnPSourceDiscovery(t *testing.T) {
+	igd := newFakeIGD(t)
+	defer igd.Close()
+
+	ssdp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ssdp.Close()
+
+	go func() {
+		buf := make([]byte, 2048)
+		n, addr, err := ssdp.ReadFrom(buf)
+		if err != nil || !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+			return
+		}
+		resp := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: " + igdSearchTarget + "\r\nLOCATION: " + igd.URL + "/rootDesc.xml\r\n\r\n"
+		ssdp.WriteTo([]byte(resp), addr)
+	}()
+
+	source := newUPnPSource("")
+	source.ssdpAddress = ssdp.LocalAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	address, err := source.GetIP(ctx, IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.Equal(net.ParseIP("198.51.100.42")) {
+		t.Errorf("Expected 198.51.100.42, got %s", address)
+	}
+}
@@ -0,0 +1,234 @@
+// Package cloudflare is a small client for the parts of the Cloudflare API v4 used by ddns-cf: zones and DNS records.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultBaseURL string = "https://api.cloudflare.com/client/v4/"
+
+// A client for the Cloudflare API that uses a single API token.
+// Requests that fail because of a connection error, a 5xx or a 429 are retried with an exponential backoff,
+// and they are delayed when the token is close to Cloudflare's rate limit.
+type Client struct {
+	// The URL the paths are added to. Defaults to DefaultBaseURL. Can be changed to use a mock in tests.
+	BaseURL string
+	// The client used to send the requests. Defaults to NewHTTPClient(0, 0).
+	HTTPClient *http.Client
+	// The API token with DNS Read and Edit permissions.
+	APIToken string
+	// Sent in the User-Agent header.
+	UserAgent string
+	// How many times to retry a failed request. Negative disables retries.
+	Retries int
+	// The delay before the first retry. It doubles on every retry, with a random jitter.
+	RetryDelay time.Duration
+	// The longest delay between retries, including the ones asked for with Retry-After.
+	MaxRetryDelay time.Duration
+	// Called after every request is sent, including retries, e.g. to collect metrics.
+	// endpoint is the path with the IDs replaced by ":id". status is 0 when there was no response.
+	OnRequest func(method string, endpoint string, status int, duration time.Duration)
+
+	budget requestBudget
+}
+
+// Creates a Client with the default options.
+func NewClient(apiToken string) *Client {
+	return &Client{
+		BaseURL:       DefaultBaseURL,
+		HTTPClient:    NewHTTPClient(0, 0),
+		APIToken:      apiToken,
+		Retries:       DefaultRetries,
+		RetryDelay:    DefaultRetryDelay,
+		MaxRetryDelay: DefaultMaxRetryDelay,
+	}
+}
+
+// The envelope of every Cloudflare API response.
+type response[T any] struct {
+	Success    bool           `json:"success"`
+	Errors     []ResponseInfo `json:"errors"`
+	Messages   []ResponseInfo `json:"messages"`
+	Result     T              `json:"result"`
+	ResultInfo *ResultInfo    `json:"result_info"`
+}
+
+// An entry in the errors or messages of a response.
+type ResponseInfo struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// The pagination information of a list response.
+type ResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
+}
+
+// Sends a request and decodes the response's result into result.
+// Returns the pagination information, if the response has it.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, requestBody any, result any) (*ResultInfo, error) {
+	endpoint := strings.TrimSuffix(c.baseURL(), "/") + "/" + strings.TrimPrefix(path, "/")
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var body []byte
+	if requestBody != nil {
+		var err error
+		body, err = json.Marshal(requestBody)
+		if err != nil {
+			return nil, &RequestError{Method: method, Path: path, Err: fmt.Errorf("Error encoding request: %w", err)}
+		}
+	}
+
+	resp, err := c.send(ctx, method, endpoint, endpointName(path), body)
+	if err != nil {
+		return nil, &RequestError{Method: method, Path: path, Err: err}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &RequestError{Method: method, Path: path, Err: fmt.Errorf("Error reading response: %w", err)}
+	}
+
+	log.WithFields(log.Fields{"status": resp.StatusCode, "responseBody": string(data)}).Trace("[cloudflare] Received response")
+
+	decoded := response[json.RawMessage]{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		// Errors from a proxy or the load balancer don't have a JSON body, but the status is still useful.
+		if resp.StatusCode >= 400 {
+			return nil, &APIError{StatusCode: resp.StatusCode}
+		}
+		return nil, &RequestError{Method: method, Path: path, Err: fmt.Errorf("%w: %s", ErrDecodeJSON, err)}
+	}
+
+	if !decoded.Success || resp.StatusCode >= 400 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Errors: decoded.Errors, Messages: decoded.Messages}
+	}
+
+	for _, info := range decoded.Messages {
+		log.WithFields(log.Fields{"method": method, "path": path, "code": info.Code, "message": info.Message}).Debug("[cloudflare] Message in response")
+	}
+
+	if result != nil && len(decoded.Result) > 0 {
+		if err := json.Unmarshal(decoded.Result, result); err != nil {
+			return nil, &RequestError{Method: method, Path: path, Err: fmt.Errorf("%w: %s", ErrDecodeJSON, err)}
+		}
+	}
+
+	return decoded.ResultInfo, nil
+}
+
+// Sends the request, retrying it when needed. The caller has to close the response's body.
+// name is the endpoint passed to OnRequest.
+func (c *Client) send(ctx context.Context, method string, endpoint string, name string, body []byte) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		if wait := c.budget.reserve(time.Now()); wait > 0 {
+			log.WithFields(log.Fields{"method": method, "url": endpoint, "wait": wait}).Warn("[cloudflare] Close to the API rate limit. Waiting")
+			if err := sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		log.WithFields(log.Fields{"method": method, "url": endpoint, "attempt": attempt}).Trace("[cloudflare] Sending request")
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating Request: %w", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+c.APIToken)
+		req.Header.Set("Content-Type", "application/json")
+		if c.UserAgent != "" {
+			req.Header.Set("User-Agent", c.UserAgent)
+		}
+
+		start := time.Now()
+		resp, err := httpClient.Do(req)
+		if resp != nil {
+			c.budget.update(resp.Header, time.Now())
+		}
+
+		if c.OnRequest != nil {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			c.OnRequest(method, name, status, time.Since(start))
+		}
+
+		if attempt < c.Retries && shouldRetry(method, resp, err) {
+			delay := retryDelay(attempt, resp, c.RetryDelay, c.MaxRetryDelay)
+			fields := log.Fields{"method": method, "url": endpoint, "attempt": attempt, "delay": delay, "err": err}
+			if resp != nil {
+				fields["status"] = resp.StatusCode
+				resp.Body.Close()
+			}
+			log.WithFields(fields).Warn("[cloudflare] Request failed. Retrying")
+
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return resp, err
+	}
+}
+
+// Returns the path with the zone and record IDs replaced by ":id", so that it can be used as a metric label.
+// e.g. "zones/:id/dns_records/:id"
+func endpointName(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "zones" || segments[i-1] == "dns_records" {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func (c *Client) baseURL() string {
+	if c.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return c.BaseURL
+}
+
+// Waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
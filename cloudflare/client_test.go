@@ -0,0 +1,255 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Creates a client that sends its requests to the handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewClient("test-token")
+	client.BaseURL = server.URL
+	client.RetryDelay = time.Millisecond
+	client.MaxRetryDelay = 5 * time.Millisecond
+	return client
+}
+
+func TestGetZoneID(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"success": false, "errors": [{"code": 9109, "message": "Unauthorized"}]}`))
+			return
+		}
+
+		if r.URL.Path != "/zones" || r.URL.Query().Get("name") != "example.com" {
+			t.Errorf("Unexpected request: %s", r.URL)
+		}
+		w.Write([]byte(`{"success": true, "errors": [], "messages": [], "result": [{"id": "zone123", "name": "example.com", "status": "active"}]}`))
+	})
+
+	zoneID, err := client.GetZoneID(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zoneID != "zone123" {
+		t.Errorf("Expected zone123, got %s", zoneID)
+	}
+
+	client.APIToken = "wrong"
+	var apiErr *APIError
+	if _, err := client.GetZoneID(context.Background(), "example.com"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an APIError with status 403, got %v", err)
+	}
+}
+
+func TestGetZoneIDNotFound(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true, "errors": [], "messages": [], "result": []}`))
+	})
+
+	if _, err := client.GetZoneID(context.Background(), "example.com"); !errors.Is(err, ErrZoneNotFound) {
+		t.Errorf("Expected ErrZoneNotFound, got %v", err)
+	}
+}
+
+func TestDNSRecords(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/zones/zone123/dns_records":
+			if r.URL.Query().Get("type") != "A" || r.URL.Query().Get("name") != "home.example.com" {
+				t.Errorf("Unexpected query: %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "A", "name": "home.example.com", "content": "192.0.2.1", "ttl": 1, "proxied": false}]}`))
+		case r.Method == "POST" && r.URL.Path == "/zones/zone123/dns_records":
+			var record DNSRecord
+			json.NewDecoder(r.Body).Decode(&record)
+			record.ID = "rec2"
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "result": record})
+		case r.Method == "PUT" && r.URL.Path == "/zones/zone123/dns_records/rec1":
+			var record DNSRecord
+			json.NewDecoder(r.Body).Decode(&record)
+			record.ID = "rec1"
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "result": record})
+		case r.Method == "PATCH" && r.URL.Path == "/zones/zone123/dns_records/rec1":
+			var patch map[string]any
+			json.NewDecoder(r.Body).Decode(&patch)
+			if len(patch) != 1 || patch["content"] != "192.0.2.3" {
+				t.Errorf("Expected only the content in the patch, got %v", patch)
+			}
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1", "type": "A", "content": "192.0.2.3", "comment": "kept"}}`))
+		case r.Method == "DELETE" && r.URL.Path == "/zones/zone123/dns_records/rec1":
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	ctx := context.Background()
+	records, err := client.ListDNSRecords(ctx, "zone123", ListDNSRecordsParams{Type: "A", Name: "home.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "rec1" || records[0].Content != "192.0.2.1" {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+
+	created, err := client.CreateDNSRecord(ctx, "zone123", DNSRecord{Type: "AAAA", Name: "home.example.com", Content: "2001:db8::1", TTL: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != "rec2" || created.Content != "2001:db8::1" {
+		t.Errorf("Unexpected created record: %+v", created)
+	}
+
+	updated, err := client.UpdateDNSRecord(ctx, "zone123", "rec1", DNSRecord{Type: "A", Name: "home.example.com", Content: "192.0.2.2", TTL: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Content != "192.0.2.2" {
+		t.Errorf("Unexpected updated record: %+v", updated)
+	}
+
+	patched, err := client.PatchDNSRecord(ctx, "zone123", "rec1", DNSRecordPatch{Content: "192.0.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Content != "192.0.2.3" || patched.Comment != "kept" {
+		t.Errorf("Unexpected patched record: %+v", patched)
+	}
+
+	if err := client.DeleteDNSRecord(ctx, "zone123", "rec1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 5xx responses should be retried, and the request should succeed once the server recovers
+func TestRetryOnServerError(t *testing.T) {
+	attempts := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"success": true, "result": [{"id": "zone123"}]}`))
+	})
+
+	if _, err := client.GetZoneID(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Without retries, the first error is returned
+	attempts = 0
+	client.Retries = 0
+	var apiErr *APIError
+	if _, err := client.GetZoneID(context.Background(), "example.com"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected an APIError with status 502, got %v", err)
+	}
+}
+
+func TestAPIErrorCodes(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"success": false, "errors": [{"code": 1004, "message": "DNS Validation Error"}, {"code": 81057, "message": "Record already exists."}], "messages": [{"code": 0, "message": "note"}], "result": null}`))
+	})
+
+	_, err := client.CreateDNSRecord(context.Background(), "zone123", DNSRecord{Type: "A", Name: "home.example.com", Content: "192.0.2.1"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || len(apiErr.Errors) != 2 || len(apiErr.Messages) != 1 {
+		t.Errorf("Unexpected APIError: %+v", apiErr)
+	}
+	if !apiErr.HasCode(1004) || apiErr.HasCode(9109) {
+		t.Errorf("HasCode returned the wrong result for %+v", apiErr)
+	}
+	if !errors.Is(err, ErrRecordExists) {
+		t.Errorf("Expected ErrRecordExists, got %v", err)
+	}
+	if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrRateLimited) {
+		t.Errorf("Unexpected sentinel match for %v", err)
+	}
+}
+
+func TestAPIErrorIs(t *testing.T) {
+	tests := []struct {
+		err    *APIError
+		target error
+		want   bool
+	}{
+		{&APIError{StatusCode: 403, Errors: []ResponseInfo{{Code: 9109, Message: "Invalid access token"}}}, ErrUnauthorized, true},
+		{&APIError{StatusCode: 400, Errors: []ResponseInfo{{Code: 10000, Message: "Authentication error"}}}, ErrUnauthorized, true},
+		{&APIError{StatusCode: 401}, ErrUnauthorized, true},
+		{&APIError{StatusCode: 400, Errors: []ResponseInfo{{Code: 7003, Message: "Could not route"}}}, ErrZoneNotFound, true},
+		{&APIError{StatusCode: 404, Errors: []ResponseInfo{{Code: 81044, Message: "Record does not exist."}}}, ErrRecordNotFound, true},
+		{&APIError{StatusCode: 429}, ErrRateLimited, true},
+		{&APIError{StatusCode: 400, Errors: []ResponseInfo{{Code: 971, Message: "Please wait and consider throttling your request speed"}}}, ErrRateLimited, true},
+		{&APIError{StatusCode: 500}, ErrRateLimited, false},
+		{&APIError{StatusCode: 400, Errors: []ResponseInfo{{Code: 9109}}}, ErrZoneNotFound, false},
+	}
+
+	for _, test := range tests {
+		if got := errors.Is(test.err, test.target); got != test.want {
+			t.Errorf("errors.Is(%v, %v) = %v, expected %v", test.err, test.target, got, test.want)
+		}
+	}
+}
+
+func TestListDNSRecordsPagination(t *testing.T) {
+	pages := map[string]string{
+		"1": `{"success": true, "result": [{"id": "rec1", "type": "A"}, {"id": "rec2", "type": "A"}], "result_info": {"page": 1, "per_page": 2, "count": 2, "total_count": 3, "total_pages": 2}}`,
+		"2": `{"success": true, "result": [{"id": "rec3", "type": "A"}], "result_info": {"page": 2, "per_page": 2, "count": 1, "total_count": 3, "total_pages": 2}}`,
+	}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("per_page") != "2" {
+			t.Errorf("Expected per_page=2, got %s", r.URL.RawQuery)
+		}
+		page, ok := pages[r.URL.Query().Get("page")]
+		if !ok {
+			t.Errorf("Unexpected page requested: %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(page))
+	})
+
+	records, err := client.ListDNSRecords(context.Background(), "zone123", ListDNSRecordsParams{Type: "A", PerPage: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[2].ID != "rec3" {
+		t.Errorf("Expected the records from both pages, got %+v", records)
+	}
+}
+
+func TestOnRequest(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+	})
+
+	var endpoints []string
+	client.OnRequest = func(method string, endpoint string, status int, duration time.Duration) {
+		if status != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", status)
+		}
+		endpoints = append(endpoints, method+" "+endpoint)
+	}
+
+	client.DeleteDNSRecord(context.Background(), "023e105f4ecef8ad9ca31a8372d0c353", "372e67954025e0ba6aaa6d586b9e0b59")
+	client.ListZones(context.Background(), "example.com")
+	if len(endpoints) != 2 || endpoints[0] != "DELETE zones/:id/dns_records/:id" || endpoints[1] != "GET zones" {
+		t.Errorf("Unexpected endpoints: %v", endpoints)
+	}
+}
@@ -0,0 +1,109 @@
+package cloudflare
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// A DNS record in a zone.
+type DNSRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	// 1 is automatic
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+	Comment string `json:"comment,omitempty"`
+	// Tags in the form "name:value"
+	Tags []string `json:"tags,omitempty"`
+}
+
+// The fields to change with PatchDNSRecord. Empty values are left as they are.
+type DNSRecordPatch struct {
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// Replaces all of the record's tags
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Filters for ListDNSRecords. Empty values are not used.
+type ListDNSRecordsParams struct {
+	Type string
+	// The FQDN of the record
+	Name string
+	// How many records to request per page. Defaults to DefaultPerPage.
+	PerPage int
+}
+
+// The page size used when ListDNSRecordsParams.PerPage is not set. 5000 is the most the API allows.
+const DefaultPerPage int = 100
+
+// Lists all of the DNS records in the zone that match the params, requesting every page.
+// https://developers.cloudflare.com/api/operations/dns-records-for-a-zone-list-dns-records
+func (c *Client) ListDNSRecords(ctx context.Context, zoneID string, params ListDNSRecordsParams) ([]DNSRecord, error) {
+	query := url.Values{}
+	if params.Type != "" {
+		query.Set("type", params.Type)
+	}
+	if params.Name != "" {
+		query.Set("name", params.Name)
+	}
+
+	perPage := params.PerPage
+	if perPage <= 0 {
+		perPage = DefaultPerPage
+	}
+	query.Set("per_page", strconv.Itoa(perPage))
+
+	var records []DNSRecord
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var pageRecords []DNSRecord
+		info, err := c.do(ctx, "GET", "zones/"+zoneID+"/dns_records", query, nil, &pageRecords)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, pageRecords...)
+
+		// Stop when the API doesn't say there are more pages. An empty page also stops it in case total_pages is wrong.
+		if info == nil || page >= info.TotalPages || len(pageRecords) == 0 {
+			return records, nil
+		}
+	}
+}
+
+// Creates a DNS record in the zone and returns it.
+// https://developers.cloudflare.com/api/operations/dns-records-for-a-zone-create-dns-record
+func (c *Client) CreateDNSRecord(ctx context.Context, zoneID string, record DNSRecord) (DNSRecord, error) {
+	var created DNSRecord
+	_, err := c.do(ctx, "POST", "zones/"+zoneID+"/dns_records", nil, record, &created)
+	return created, err
+}
+
+// Overwrites a DNS record and returns it.
+// https://developers.cloudflare.com/api/operations/dns-records-for-a-zone-update-dns-record
+func (c *Client) UpdateDNSRecord(ctx context.Context, zoneID string, recordID string, record DNSRecord) (DNSRecord, error) {
+	var updated DNSRecord
+	_, err := c.do(ctx, "PUT", "zones/"+zoneID+"/dns_records/"+recordID, nil, record, &updated)
+	return updated, err
+}
+
+// Changes only the fields set in patch and returns the record. Comments, tags and the fields not set are kept.
+// https://developers.cloudflare.com/api/operations/dns-records-for-a-zone-patch-dns-record
+func (c *Client) PatchDNSRecord(ctx context.Context, zoneID string, recordID string, patch DNSRecordPatch) (DNSRecord, error) {
+	var updated DNSRecord
+	_, err := c.do(ctx, "PATCH", "zones/"+zoneID+"/dns_records/"+recordID, nil, patch, &updated)
+	return updated, err
+}
+
+// Deletes a DNS record.
+// https://developers.cloudflare.com/api/operations/dns-records-for-a-zone-delete-dns-record
+func (c *Client) DeleteDNSRecord(ctx context.Context, zoneID string, recordID string) error {
+	_, err := c.do(ctx, "DELETE", "zones/"+zoneID+"/dns_records/"+recordID, nil, nil, nil)
+	return err
+}
@@ -0,0 +1,102 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrDecodeJSON   = errors.New("failed to decode JSON")
+	ErrZoneNotFound = errors.New("zone not found")
+	// The API token is invalid, expired or doesn't have the permissions needed for the request.
+	ErrUnauthorized = errors.New("not authorized")
+	// A record with the same name and type, or one that conflicts with it, already exists.
+	ErrRecordExists = errors.New("record already exists")
+	// The record ID doesn't exist in the zone. The record was probably deleted.
+	ErrRecordNotFound = errors.New("record not found")
+	ErrRateLimited    = errors.New("rate limited")
+)
+
+// The Cloudflare error codes that map to each sentinel error.
+// https://developers.cloudflare.com/fundamentals/api/reference/troubleshooting/
+var errorCodes = map[int]error{
+	6003:  ErrUnauthorized, // Invalid request headers
+	6111:  ErrUnauthorized, // Invalid format for Authorization header
+	9103:  ErrUnauthorized, // Unknown X-Auth-Key or X-Auth-Email
+	9109:  ErrUnauthorized, // Invalid access token
+	10000: ErrUnauthorized, // Authentication error
+	1001:  ErrZoneNotFound, // Invalid zone identifier
+	7003:  ErrZoneNotFound, // Could not route to the path, the identifier is invalid
+	81044: ErrRecordNotFound,
+	81053: ErrRecordExists, // An A, AAAA, or CNAME record with that host already exists
+	81057: ErrRecordExists, // The record already exists
+	81058: ErrRecordExists, // An identical record already exists
+	971:   ErrRateLimited,
+}
+
+// An error sending a request to the Cloudflare API or reading its response.
+// The request might not have reached Cloudflare.
+type RequestError struct {
+	Method string
+	Path   string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// An error returned by the Cloudflare API. The response had "success": false or an error status.
+//
+// errors.Is can be used to check for ErrUnauthorized, ErrZoneNotFound, ErrRecordExists, ErrRecordNotFound and ErrRateLimited.
+type APIError struct {
+	// The HTTP status code of the response
+	StatusCode int
+	Errors     []ResponseInfo
+	Messages   []ResponseInfo
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("cloudflare: request failed with status %d", e.StatusCode)
+	}
+
+	messages := make([]string, 0, len(e.Errors))
+	for _, info := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%d: %s", info.Code, info.Message))
+	}
+	return fmt.Sprintf("cloudflare: %s (status %d)", strings.Join(messages, "; "), e.StatusCode)
+}
+
+// Reports whether any of the error codes, or the status code, maps to target.
+func (e *APIError) Is(target error) bool {
+	for _, info := range e.Errors {
+		if errorCodes[info.Code] == target {
+			return true
+		}
+	}
+
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	}
+	return false
+}
+
+// Reports whether any of the errors has the code.
+func (e *APIError) HasCode(code int) bool {
+	for _, info := range e.Errors {
+		if info.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetries        int           = 3
+	DefaultRetryDelay     time.Duration = time.Second
+	DefaultMaxRetryDelay  time.Duration = 30 * time.Second
+	DefaultTimeout        time.Duration = 30 * time.Second
+	DefaultConnectTimeout time.Duration = 10 * time.Second
+
+	// Cloudflare's global API rate limit: 1200 requests per 5 minutes per user.
+	// https://developers.cloudflare.com/fundamentals/api/reference/limits/
+	rateLimitRequests int           = 1200
+	rateLimitWindow   time.Duration = 5 * time.Minute
+)
+
+// Creates an http.Client with connect and overall timeouts, so that a hung connection can't block forever.
+// Zero values use DefaultConnectTimeout and DefaultTimeout.
+func NewHTTPClient(connectTimeout, timeout time.Duration) *http.Client {
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// Returns true if a request that failed with err or got resp should be sent again.
+// Requests that are not idempotent (POST) are only retried when Cloudflare rate limited them, since they might have been applied.
+func shouldRetry(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		return method != http.MethodPost || isConnectError(err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return resp.StatusCode >= 500 && method != http.MethodPost
+}
+
+// Returns true if the connection could not be made, so the request never reached the server.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Returns how long to wait before sending the request again.
+// Retry-After is used when the response has it, otherwise it is an exponential backoff with full jitter.
+// It is never longer than maxDelay, so that a large Retry-After can't keep the program waiting for hours.
+func retryDelay(attempt int, resp *http.Response, baseDelay, maxDelay time.Duration) time.Duration {
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxRetryDelay
+	}
+
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			// Cloudflare knows better than the local limit
+			return min(delay, maxDelay)
+		}
+	}
+
+	if baseDelay <= 0 {
+		baseDelay = DefaultRetryDelay
+	}
+
+	delay := baseDelay << attempt
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// Parses a Retry-After header. It can be the amount of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// Keeps track of the requests sent with an API token to stay under Cloudflare's rate limit.
+type requestBudget struct {
+	mu sync.Mutex
+	// When the requests in the current window were sent, oldest first
+	sent []time.Time
+	// Set when Cloudflare says that there are no requests left
+	blockedUntil time.Time
+}
+
+// Reserves a request and returns how long to wait before sending it.
+func (b *requestBudget) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Forget the requests that are out of the window
+	windowStart := now.Add(-rateLimitWindow)
+	for len(b.sent) > 0 && !b.sent[0].After(windowStart) {
+		b.sent = b.sent[1:]
+	}
+
+	sendAt := now
+	if len(b.sent) >= rateLimitRequests {
+		// Wait until the oldest request in the window expires
+		sendAt = b.sent[len(b.sent)-rateLimitRequests].Add(rateLimitWindow)
+	}
+	if b.blockedUntil.After(sendAt) {
+		sendAt = b.blockedUntil
+	}
+
+	b.sent = append(b.sent, sendAt)
+	return sendAt.Sub(now)
+}
+
+// Updates the budget with Cloudflare's Ratelimit header, e.g. `"default";r=50;t=30`.
+// r is the amount of requests left and t the seconds until the window resets.
+func (b *requestBudget) update(header http.Header, now time.Time) {
+	remaining, reset, ok := parseRateLimitHeader(header.Get("Ratelimit"))
+	if !ok || remaining > 0 {
+		return
+	}
+
+	// The limit is per window, so it can't take longer than that to reset
+	reset = min(reset, rateLimitWindow)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blockedUntil = now.Add(reset)
+}
+
+// Parses the remaining requests (r) and the reset time (t) in a Ratelimit header.
+func parseRateLimitHeader(value string) (int, time.Duration, bool) {
+	if value == "" {
+		return 0, 0, false
+	}
+
+	remaining, reset := -1, -1
+	for _, part := range strings.Split(value, ";") {
+		key, val, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+
+		number, err := strconv.Atoi(val)
+		if err != nil {
+			continue
+		}
+
+		switch key {
+		case "r":
+			remaining = number
+		case "t":
+			reset = number
+		}
+	}
+
+	if remaining < 0 || reset < 0 {
+		return 0, 0, false
+	}
+	return remaining, time.Duration(reset) * time.Second, true
+}
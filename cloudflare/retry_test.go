@@ -0,0 +1,106 @@
+package cloudflare
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if delay, ok := parseRetryAfter("120", now); !ok || delay != 2*time.Minute {
+		t.Errorf("Expected 2m, got %s %t", delay, ok)
+	}
+
+	if delay, ok := parseRetryAfter("Mon, 01 Jan 2024 12:00:30 GMT", now); !ok || delay != 30*time.Second {
+		t.Errorf("Expected 30s, got %s %t", delay, ok)
+	}
+
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Error("Expected an invalid value to be ignored")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := retryDelay(attempt, nil, time.Second, 5*time.Second)
+		if delay <= 0 || delay > 5*time.Second {
+			t.Errorf("Delay out of range for attempt %d: %s", attempt, delay)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"7"}}}
+	if delay := retryDelay(0, resp, time.Second, 10*time.Second); delay != 7*time.Second {
+		t.Errorf("Expected Retry-After to be used, got %s", delay)
+	}
+
+	resp = &http.Response{Header: http.Header{"Retry-After": []string{"86400"}}}
+	if delay := retryDelay(0, resp, time.Second, 30*time.Second); delay != 30*time.Second {
+		t.Errorf("Expected Retry-After to be capped at the max delay, got %s", delay)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+
+	tests := []struct {
+		method   string
+		status   int
+		err      error
+		expected bool
+	}{
+		{http.MethodGet, http.StatusOK, nil, false},
+		{http.MethodGet, http.StatusBadRequest, nil, false},
+		{http.MethodGet, http.StatusBadGateway, nil, true},
+		{http.MethodGet, http.StatusTooManyRequests, nil, true},
+		{http.MethodGet, 0, readErr, true},
+		{http.MethodPost, http.StatusBadGateway, nil, false},
+		{http.MethodPost, http.StatusTooManyRequests, nil, true},
+		{http.MethodPost, 0, readErr, false},
+		{http.MethodPost, 0, dialErr, true},
+	}
+
+	for _, test := range tests {
+		var resp *http.Response
+		if test.err == nil {
+			resp = &http.Response{StatusCode: test.status}
+		}
+		if shouldRetry(test.method, resp, test.err) != test.expected {
+			t.Errorf("%s %d %v: expected %t", test.method, test.status, test.err, test.expected)
+		}
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	budget := &requestBudget{}
+	now := time.Now()
+
+	for i := 0; i < rateLimitRequests; i++ {
+		if wait := budget.reserve(now); wait != 0 {
+			t.Fatalf("Expected no wait for request %d, got %s", i, wait)
+		}
+	}
+
+	// The budget is used, the next request has to wait for the window
+	if wait := budget.reserve(now); wait != rateLimitWindow {
+		t.Errorf("Expected to wait %s, got %s", rateLimitWindow, wait)
+	}
+
+	// Cloudflare's header takes precedence
+	budget = &requestBudget{}
+	budget.update(http.Header{"Ratelimit": []string{`"default";r=0;t=30`}}, now)
+	if wait := budget.reserve(now); wait != 30*time.Second {
+		t.Errorf("Expected to wait 30s, got %s", wait)
+	}
+
+	// A reset longer than the window is capped
+	budget = &requestBudget{}
+	budget.update(http.Header{"Ratelimit": []string{`"default";r=0;t=86400`}}, now)
+	if wait := budget.reserve(now); wait != rateLimitWindow {
+		t.Errorf("Expected to wait %s, got %s", rateLimitWindow, wait)
+	}
+}
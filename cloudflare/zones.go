@@ -0,0 +1,39 @@
+package cloudflare
+
+import (
+	"context"
+	"net/url"
+)
+
+// A Cloudflare zone.
+type Zone struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// Lists the zones with the name. The token only sees the zones it has access to.
+// https://developers.cloudflare.com/api/operations/zones-get
+func (c *Client) ListZones(ctx context.Context, name string) ([]Zone, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	var zones []Zone
+	_, err := c.do(ctx, "GET", "zones", query, nil, &zones)
+	return zones, err
+}
+
+// Returns the ID of the zone with the name.
+func (c *Client) GetZoneID(ctx context.Context, name string) (string, error) {
+	zones, err := c.ListZones(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(zones) == 0 || zones[0].ID == "" {
+		return "", ErrZoneNotFound
+	}
+	return zones[0].ID, nil
+}
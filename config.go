@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
+
+	"mtzfederico/ddns-cf/cloudflare"
 
 	log "github.com/sirupsen/logrus"
 
@@ -10,8 +14,6 @@ import (
 )
 
 type Config struct {
-	// Complete FQDN to update. Set by the program.
-	name string
 	//  The domain name to update
 	Domain string `yaml:"Domain" binding:"required"`
 	// The Cloudflare Zone ID for the Domain. If left empty, it will be fetched from Cloudflare. Setting it removes the need for an extra API call.
@@ -42,6 +44,134 @@ type Config struct {
 	LogFile string `yaml:"LogFile"`
 	// The level of details to log. The options from less detail to very detailed are: panic, fatal, error, warning, info, debug, and trace
 	LogLevel string `yaml:"LogLevel"`
+	// The records to keep updated in Domain. If left empty, a single record is created from SubDomainToUpdate.
+	Records []*Record `yaml:"Records"`
+	// The zones to keep updated. If left empty, a single zone is created from Domain, DomainZoneID, APIKey and Records.
+	Zones []*Zone `yaml:"Zones"`
+	// How often to check the IP address in daemon mode. e.g. "150s" or "5m". Defaults to 150s.
+	Interval time.Duration `yaml:"Interval"`
+	// A random amount of time, up to this value, that gets added to or removed from the Interval in daemon mode.
+	IntervalJitter time.Duration `yaml:"IntervalJitter"`
+	// The network interfaces to watch for address changes in daemon mode (Linux only). A change triggers a check right away.
+	WatchInterfaces []string `yaml:"WatchInterfaces"`
+	// The sources used to get the public IPv4 address, in order. If one fails, the next one is used. Defaults to icanhazip.
+	IPv4Sources []IPSourceConfig `yaml:"IPv4Sources"`
+	// The sources used to get the public IPv6 address, in order. If one fails, the next one is used. Defaults to icanhazip.
+	IPv6Sources []IPSourceConfig `yaml:"IPv6Sources"`
+	// If set, all of the IPv4Sources are asked in parallel and the address is only used when at least this many of them agree. It has to be more than half of the sources.
+	IPv4SourcesQuorum int `yaml:"IPv4SourcesQuorum"`
+	// If set, all of the IPv6Sources are asked in parallel and the address is only used when at least this many of them agree. It has to be more than half of the sources.
+	IPv6SourcesQuorum int `yaml:"IPv6SourcesQuorum"`
+	// How many times to retry a Cloudflare API request that failed because of a connection error, a 5xx or a 429. Defaults to 3, -1 disables retries.
+	APIRetries int `yaml:"APIRetries"`
+	// The delay before the first retry. It doubles on every retry, with a random jitter. Defaults to 1s.
+	APIRetryDelay time.Duration `yaml:"APIRetryDelay"`
+	// The longest delay between retries, including the ones asked for with Retry-After. Defaults to 30s.
+	APIMaxRetryDelay time.Duration `yaml:"APIMaxRetryDelay"`
+	// How long a Cloudflare API request can take, including reading the response. Defaults to 30s.
+	APITimeout time.Duration `yaml:"APITimeout"`
+	// How long to wait for a connection to Cloudflare to be established. Defaults to 10s.
+	ConnectTimeout time.Duration `yaml:"ConnectTimeout"`
+	// The base URL of the Cloudflare API. Defaults to https://api.cloudflare.com/client/v4/
+	APIBaseURL string `yaml:"APIBaseURL"`
+	// What to do when a name has more than one record of the same type: "refuse", "update-all" or "update-one". Defaults to "refuse".
+	DuplicateRecords string `yaml:"DuplicateRecords"`
+	// Set the records' TTL to RecordTTL on every update. Otherwise only the IP address is changed and the TTL set in the dashboard is kept.
+	EnforceTTL bool `yaml:"EnforceTTL"`
+	// Set the records' proxy status to IsProxied on every update. Otherwise only the IP address is changed and the proxy status set in the dashboard is kept.
+	EnforceProxied bool `yaml:"EnforceProxied"`
+	// How ddns-cf marks the records it manages: "comment", "tag", "txt" or "none". Records without the marker are not changed unless -adopt is used. Defaults to "comment".
+	OwnershipMarker string `yaml:"OwnershipMarker"`
+	// Identifies this instance of ddns-cf in the ownership marker, so that several instances can share a zone. Defaults to "default".
+	InstanceID string `yaml:"InstanceID"`
+	// Don't create records that don't exist yet. Only the existing ones are updated.
+	DisableCreate bool `yaml:"DisableCreate"`
+	// What happens to the records when the address of their IP version can't be found: "keep", "delete-after" or "replace-with". Defaults to "keep".
+	OnAddressLost string `yaml:"OnAddressLost"`
+	// How long the address has to be missing before the OnAddressLost policy is applied. Defaults to 1h, so that a single failed check doesn't change the records.
+	AddressLostAfter time.Duration `yaml:"AddressLostAfter"`
+	// The length of the delegated IPv6 prefix that is combined with the records' IPv6Suffix. Defaults to 64.
+	IPv6PrefixLength int `yaml:"IPv6PrefixLength"`
+	// Detected addresses in these ranges are always published, even if they are private or reserved. e.g. ["100.64.0.0/10"]
+	AllowedIPRanges []string `yaml:"AllowedIPRanges"`
+	// Detected addresses in these ranges are never published.
+	DeniedIPRanges []string `yaml:"DeniedIPRanges"`
+	// The address of the HTTP server in daemon mode, e.g. ":9101". It serves /metrics, /healthz, /status and /update. If left empty, the server is not started.
+	ListenAddress string `yaml:"ListenAddress"`
+	// If set, POST /update requires the "Authorization: Bearer <UpdateToken>" header.
+	UpdateToken string `yaml:"UpdateToken"`
+	// /healthz fails after this many runs in a row where none of the records could be checked or updated. Defaults to 3.
+	HealthMaxFailedRuns int `yaml:"HealthMaxFailedRuns"`
+	// /healthz fails when the last successful run is older than this. If left empty, it isn't checked.
+	HealthMaxAge time.Duration `yaml:"HealthMaxAge"`
+	// The services notified when a record changes or an update fails, e.g. webhooks.
+	Notifiers []NotifierConfig `yaml:"Notifiers"`
+}
+
+// The options for DuplicateRecords.
+const (
+	// Don't update any of the records and call the error script.
+	duplicatesRefuse string = "refuse"
+	// Point all of the records to the new IP.
+	duplicatesUpdateAll string = "update-all"
+	// Keep the record that already has the address (or the first one) and delete the rest.
+	duplicatesUpdateOne string = "update-one"
+)
+
+// A Cloudflare zone and the records in it to keep updated.
+type Zone struct {
+	// The zone's domain name
+	Domain string `yaml:"Domain" binding:"required"`
+	// The Cloudflare Zone ID for the Domain. If left empty, it will be fetched from Cloudflare.
+	DomainZoneID string `yaml:"DomainZoneID"`
+	// The Cloudflare Account Token with DNS Read and Edit permissions for this zone. If left empty, the global APIKey is used.
+	APIKey string `yaml:"APIKey"`
+	// The records to keep updated in this zone. If left empty, the Domain itself is used.
+	Records []*Record `yaml:"Records"`
+	// The client for the zone's APIKey. Set by the program.
+	client *cloudflare.Client
+}
+
+// A DNS record kept up to date by the program.
+// Empty values fall back to the global options in Config.
+type Record struct {
+	// Complete FQDN to update. Set by the program.
+	fqdn string
+	// The zone that the record is in. Set by the program.
+	zone *Zone
+	// The subdomain of the zone's Domain to update. If left empty or set to '@', the Domain itself is used.
+	Name string `yaml:"Name"`
+	// The record types to keep updated ("A" and/or "AAAA"). If left empty, both are used, or only "AAAA" if IPv6Suffix is set.
+	RecordTypes []string `yaml:"RecordTypes"`
+	// The TTL assigned to the record in seconds. 1 sets it to cloudflare's automatic option.
+	TTL int `yaml:"TTL"`
+	// Use Cloudflare to proxy the record's traffic.
+	IsProxied *bool `yaml:"IsProxied"`
+	// The path to a script or binary that gets executed when the record's IP address changes.
+	ScriptOnChange string `yaml:"ScriptOnChange"`
+	// The path to a script or binary that gets executed when there is an error updating the record.
+	ScriptOnError string `yaml:"ScriptOnError"`
+	// What to do when the name has more than one record of the same type. If left empty, the global DuplicateRecords is used.
+	DuplicateRecords string `yaml:"DuplicateRecords"`
+	// Set the record's TTL on every update. If left empty, the global EnforceTTL is used.
+	EnforceTTL *bool `yaml:"EnforceTTL"`
+	// Set the record's proxy status on every update. If left empty, the global EnforceProxied is used.
+	EnforceProxied *bool `yaml:"EnforceProxied"`
+	// Don't create the record if it doesn't exist yet. If left empty, the global DisableCreate is used.
+	DisableCreate *bool `yaml:"DisableCreate"`
+	// What happens to the record when the address of its IP version can't be found. If left empty, the global OnAddressLost is used.
+	OnAddressLost string `yaml:"OnAddressLost"`
+	// How long the address has to be missing before the OnAddressLost policy is applied. e.g. "6h". If left empty, the global AddressLostAfter is used.
+	AddressLostAfter time.Duration `yaml:"AddressLostAfter"`
+	// The addresses the record is pointed to by the "replace-with" policy. One for each IP version. e.g. ["192.0.2.1", "2001:db8::1"]
+	ReplaceWith []string `yaml:"ReplaceWith"`
+	// The host part of the record's IPv6 address, e.g. "::1:2e0:4cff:fe68:1234". It gets combined with the prefix of the detected IPv6 address,
+	// so that hosts in the LAN can be published from a single ddns-cf when the ISP changes the delegated prefix.
+	IPv6Suffix string `yaml:"IPv6Suffix"`
+	// The length of the prefix combined with IPv6Suffix. If left empty, the global IPv6PrefixLength is used.
+	IPv6PrefixLength int `yaml:"IPv6PrefixLength"`
+	// The IDs of the record in Cloudflare for each IP version. Kept between checks in daemon mode.
+	recordIDs map[IPVersion]string
 }
 
 func (c *Config) get(configPath string) *Config {
@@ -54,18 +184,190 @@ func (c *Config) get(configPath string) *Config {
 		log.Fatalf("Unmarshal: %v", err)
 	}
 
-	c.name = ""
-	if c.SubDomainToUpdate == "" {
-		c.name = c.Domain
-	} else {
-		c.name = fmt.Sprintf("%s.%s", c.SubDomainToUpdate, c.Domain)
+	// Otherwise a half-migrated config would silently stop updating these records
+	if ignored := c.ignoredByZones(); len(ignored) > 0 {
+		log.WithFields(log.Fields{"options": strings.Join(ignored, ", ")}).Fatal("[Config Get] These options are ignored when Zones is set. Move them into a zone")
+	}
+
+	if len(c.Records) == 0 {
+		c.Records = []*Record{{Name: c.SubDomainToUpdate}}
 	}
 
-	log.WithFields(log.Fields{"Domain": c.Domain, "SubDomainToUpdate": c.SubDomainToUpdate, "APIKey": c.APIKey, "RecordTTL": c.RecordTTL, "IsProxied": c.IsProxied, "DisableIPv4": c.DisableIPv4, "DisableIPv6": c.DisableIPv6, "ScriptOnChange": c.ScriptOnChange, "LogFile": c.LogFile, "LogLevel": c.LogLevel}).Trace("Config options")
+	if len(c.Zones) == 0 {
+		c.Zones = []*Zone{{Domain: c.Domain, DomainZoneID: c.DomainZoneID, APIKey: c.APIKey, Records: c.Records}}
+	}
+
+	for _, zone := range c.Zones {
+		if zone.APIKey == "" {
+			zone.APIKey = c.APIKey
+		}
+
+		if len(zone.Records) == 0 {
+			zone.Records = []*Record{{}}
+		}
+
+		for _, record := range zone.Records {
+			record.zone = zone
+			record.fqdn = getFQDN(record.Name, zone.Domain)
+			record.recordIDs = make(map[IPVersion]string)
+		}
+	}
+
+	log.WithFields(log.Fields{"Domain": c.Domain, "SubDomainToUpdate": c.SubDomainToUpdate, "APIKey": c.APIKey, "RecordTTL": c.RecordTTL, "IsProxied": c.IsProxied, "DisableIPv4": c.DisableIPv4, "DisableIPv6": c.DisableIPv6, "ScriptOnChange": c.ScriptOnChange, "LogFile": c.LogFile, "LogLevel": c.LogLevel, "Records": len(c.Records), "Zones": len(c.Zones)}).Trace("Config options")
 
 	return c
 }
 
+// Returns the top-level options that are set but not used because Zones is set.
+func (c *Config) ignoredByZones() []string {
+	if len(c.Zones) == 0 {
+		return nil
+	}
+
+	var ignored []string
+	if c.Domain != "" {
+		ignored = append(ignored, "Domain")
+	}
+	if c.DomainZoneID != "" {
+		ignored = append(ignored, "DomainZoneID")
+	}
+	if c.SubDomainToUpdate != "" {
+		ignored = append(ignored, "SubDomainToUpdate")
+	}
+	if len(c.Records) > 0 {
+		ignored = append(ignored, "Records")
+	}
+	return ignored
+}
+
+// Returns the FQDN for a subdomain of domain. An empty subdomain or '@' returns the domain itself.
+func getFQDN(subdomain, domain string) string {
+	if subdomain == "" || subdomain == "@" {
+		return domain
+	}
+	return fmt.Sprintf("%s.%s", subdomain, domain)
+}
+
+// Returns true if the record is configured to keep the record type for the IPVersion updated.
+func (r *Record) manages(version IPVersion) bool {
+	recordType := version.getRecordType()
+	if recordType == "" {
+		return false
+	}
+
+	if len(r.RecordTypes) == 0 {
+		// A suffix is for a host in the LAN. The router's public IPv4 address is almost never meant to be published under its name.
+		return r.IPv6Suffix == "" || version == IPv6
+	}
+
+	for _, t := range r.RecordTypes {
+		if strings.EqualFold(t, recordType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the record's TTL, falling back to the global RecordTTL and then to automatic.
+func (r *Record) ttl() int {
+	if r.TTL != 0 {
+		return r.TTL
+	}
+	if conf.RecordTTL != 0 {
+		return conf.RecordTTL
+	}
+	return 1 // 1 is Automatic
+}
+
+func (r *Record) isProxied() bool {
+	if r.IsProxied != nil {
+		return *r.IsProxied
+	}
+	return conf.IsProxied
+}
+
+func (r *Record) enforceTTL() bool {
+	if r.EnforceTTL != nil {
+		return *r.EnforceTTL
+	}
+	return conf.EnforceTTL
+}
+
+func (r *Record) enforceProxied() bool {
+	if r.EnforceProxied != nil {
+		return *r.EnforceProxied
+	}
+	return conf.EnforceProxied
+}
+
+// Reports whether the existing record's TTL or proxy status differs from the enforced ones.
+func (r *Record) settingsDiffer(current cloudflare.DNSRecord) bool {
+	return (r.enforceTTL() && current.TTL != r.ttl()) || (r.enforceProxied() && current.Proxied != r.isProxied())
+}
+
+func (r *Record) disableCreate() bool {
+	if r.DisableCreate != nil {
+		return *r.DisableCreate
+	}
+	return conf.DisableCreate
+}
+
+// Returns the record's OnAddressLost policy, falling back to the global one and then to "keep".
+func (r *Record) onAddressLost() string {
+	if r.OnAddressLost != "" {
+		return r.OnAddressLost
+	}
+	if conf.OnAddressLost != "" {
+		return conf.OnAddressLost
+	}
+	return addressLostKeep
+}
+
+// Returns how long the address has to be missing before the OnAddressLost policy is applied, falling back to the global one and then to 1h.
+func (r *Record) addressLostAfter() time.Duration {
+	if r.AddressLostAfter > 0 {
+		return r.AddressLostAfter
+	}
+	if conf.AddressLostAfter > 0 {
+		return conf.AddressLostAfter
+	}
+	return defaultAddressLostAfter
+}
+
+func (r *Record) scriptOnChange() string {
+	if r.ScriptOnChange != "" {
+		return r.ScriptOnChange
+	}
+	return conf.ScriptOnChange
+}
+
+func (r *Record) scriptOnError() string {
+	if r.ScriptOnError != "" {
+		return r.ScriptOnError
+	}
+	return conf.ScriptOnError
+}
+
+// Returns the record's DuplicateRecords policy, falling back to the global one and then to "refuse".
+func (r *Record) duplicateRecords() string {
+	if r.DuplicateRecords != "" {
+		return r.DuplicateRecords
+	}
+	if conf.DuplicateRecords != "" {
+		return conf.DuplicateRecords
+	}
+	return duplicatesRefuse
+}
+
+// Reports whether policy is one of the DuplicateRecords options.
+func isValidDuplicatesPolicy(policy string) bool {
+	switch policy {
+	case "", duplicatesRefuse, duplicatesUpdateAll, duplicatesUpdateOne:
+		return true
+	}
+	return false
+}
+
 func setupLogOutput() {
 	if conf.LogFile == "" {
 		log.Info("[setupLogOutput] No LogFile specified. Logging to stderr")
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +15,7 @@ func TestParseConfig(t *testing.T) {
 
 	conf.get("sampleConfig.yaml")
 
-	if conf.name != "<subdomain>.<domain.tld>" {
+	if len(conf.Records) != 1 || conf.Records[0].fqdn != "<subdomain>.<domain.tld>" {
 		t.Errorf("Unexpected Domain value, got: %s", conf.Domain)
 	}
 
@@ -61,3 +64,103 @@ func TestParseConfig(t *testing.T) {
 		t.Errorf("Unexpected DebugLevel value, got: %s", conf.Domain)
 	}
 }
+
+func TestParseConfigRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `Domain: "example.com"
+APIKey: "key"
+RecordTTL: 300
+ScriptOnChange: "global.sh"
+Records:
+  - Name: "@"
+  - Name: "home"
+    RecordTypes: ["AAAA"]
+    TTL: 60
+    IsProxied: true
+    ScriptOnChange: "home.sh"
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	c.get(path)
+	conf = c
+	defer func() { conf = Config{} }()
+
+	if len(c.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(c.Records))
+	}
+
+	apex, home := c.Records[0], c.Records[1]
+	if apex.fqdn != "example.com" {
+		t.Errorf("Unexpected apex fqdn, got: %s", apex.fqdn)
+	}
+	if !apex.manages(IPv4) || !apex.manages(IPv6) {
+		t.Error("Expected apex to manage both IP versions")
+	}
+	if apex.ttl() != 300 || apex.scriptOnChange() != "global.sh" || apex.isProxied() {
+		t.Errorf("Expected apex to use the global options, got ttl: %d script: %s", apex.ttl(), apex.scriptOnChange())
+	}
+
+	if home.fqdn != "home.example.com" {
+		t.Errorf("Unexpected home fqdn, got: %s", home.fqdn)
+	}
+	if home.manages(IPv4) || !home.manages(IPv6) {
+		t.Error("Expected home to only manage IPv6")
+	}
+	if home.ttl() != 60 || home.scriptOnChange() != "home.sh" || !home.isProxied() {
+		t.Errorf("Expected home to use its own options, got ttl: %d script: %s", home.ttl(), home.scriptOnChange())
+	}
+}
+
+func TestParseConfigZones(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `APIKey: "globalKey"
+Zones:
+  - Domain: "example.com"
+    Records:
+      - Name: "home"
+  - Domain: "example.net"
+    DomainZoneID: "netZoneID"
+    APIKey: "netKey"
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	c.get(path)
+
+	if len(c.Zones) != 2 {
+		t.Fatalf("Expected 2 zones, got %d", len(c.Zones))
+	}
+
+	com, net := c.Zones[0], c.Zones[1]
+	if com.APIKey != "globalKey" {
+		t.Errorf("Expected the zone to use the global APIKey, got: %s", com.APIKey)
+	}
+	if com.Records[0].fqdn != "home.example.com" || com.Records[0].zone != com {
+		t.Errorf("Unexpected record in example.com, got: %s", com.Records[0].fqdn)
+	}
+
+	if net.APIKey != "netKey" || net.DomainZoneID != "netZoneID" {
+		t.Errorf("Unexpected example.net options, got APIKey: %s DomainZoneID: %s", net.APIKey, net.DomainZoneID)
+	}
+	// A zone without records uses the domain itself
+	if len(net.Records) != 1 || net.Records[0].fqdn != "example.net" {
+		t.Errorf("Expected example.net to have a record for the domain itself")
+	}
+}
+
+func TestIgnoredByZones(t *testing.T) {
+	c := Config{Domain: "example.com", Records: []*Record{{Name: "home"}}}
+	if ignored := c.ignoredByZones(); len(ignored) != 0 {
+		t.Errorf("Expected nothing to be ignored without Zones, got %v", ignored)
+	}
+
+	c.Zones = []*Zone{{Domain: "example.net"}}
+	if ignored := c.ignoredByZones(); strings.Join(ignored, ",") != "Domain,Records" {
+		t.Errorf("Expected Domain and Records to be ignored, got %v", ignored)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The interval used when none is set. Matches the systemd timer.
+	defaultInterval time.Duration = 150 * time.Second
+	// The shortest time to wait between checks, even with jitter.
+	minInterval time.Duration = 10 * time.Second
+	// How long to wait after an address change before checking. A reconnect usually changes several addresses at once.
+	watchDebounce time.Duration = 2 * time.Second
+)
+
+// Checks and updates the records on an interval until ctx is cancelled by SIGTERM or SIGINT.
+// SIGUSR1 triggers an immediate check (unix only), and so do POST /update and an address change in WatchInterfaces (Linux only).
+func runDaemon(ctx context.Context) {
+	trigger := make(chan os.Signal, 1)
+	notifyTriggerSignal(trigger)
+
+	// One pending change is enough to trigger a check
+	changes := make(chan string, 1)
+	if len(conf.WatchInterfaces) > 0 {
+		go func() {
+			err := watchInterfaces(conf.WatchInterfaces, changes)
+			log.WithFields(log.Fields{"error": err, "interfaces": conf.WatchInterfaces}).Error("[runDaemon] Stopped watching interfaces. Only the Interval is used")
+		}()
+	}
+
+	server := startHTTPServer()
+	defer stopHTTPServer(server)
+
+	// Check right away
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	log.WithFields(log.Fields{"interval": getInterval(), "jitter": conf.IntervalJitter}).Info("[runDaemon] Starting daemon")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("[runDaemon] Shutting down")
+			return
+		case sig := <-trigger:
+			log.WithField("signal", sig).Info("[runDaemon] Check triggered by signal")
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-updateRequests:
+			log.Info("[runDaemon] Check requested over HTTP")
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case iface := <-changes:
+			log.WithField("interface", iface).Info("[runDaemon] Address changed. Checking soon")
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(watchDebounce)
+			continue
+		case <-timer.C:
+		}
+
+		result := runOnce()
+		if runAborted() {
+			log.Info("[runDaemon] Shutting down in the middle of a check")
+			return
+		}
+		state.runFinished(result)
+		if result.failed > 0 {
+			log.WithFields(log.Fields{"succeeded": result.succeeded, "failed": result.failed}).Warn("[runDaemon] Some records failed. Retrying on the next check")
+		}
+
+		next := nextInterval()
+		log.WithField("next", next).Debug("[runDaemon] Waiting for next check")
+		timer.Reset(next)
+	}
+}
+
+// Checks and updates the records for every IP version enabled.
+// A failure in one IP version doesn't stop the other one from being checked.
+func runOnce() runResult {
+	var result runResult
+
+	if !conf.DisableIPv4 {
+		result.merge(updateIP(IPv4))
+	}
+
+	if !conf.DisableIPv6 && !runAborted() {
+		result.merge(updateIP(IPv6))
+	}
+
+	return result
+}
+
+// Returns the configured interval or the default one.
+func getInterval() time.Duration {
+	if conf.Interval <= 0 {
+		return defaultInterval
+	}
+	return conf.Interval
+}
+
+// Returns the time to wait until the next check: the interval plus or minus a random jitter.
+func nextInterval() time.Duration {
+	interval := getInterval()
+
+	if conf.IntervalJitter > 0 {
+		interval += time.Duration(rand.Int63n(2*int64(conf.IntervalJitter)+1)) - conf.IntervalJitter
+	}
+
+	if interval < minInterval {
+		return minInterval
+	}
+	return interval
+}
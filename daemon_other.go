@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// SIGUSR1 is not available on this platform.
+func notifyTriggerSignal(c chan<- os.Signal) {}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextInterval(t *testing.T) {
+	defer func() { conf = Config{} }()
+
+	conf = Config{}
+	if nextInterval() != defaultInterval {
+		t.Errorf("Expected the default interval, got %s", nextInterval())
+	}
+
+	conf = Config{Interval: time.Minute, IntervalJitter: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		next := nextInterval()
+		if next < 50*time.Second || next > 70*time.Second {
+			t.Fatalf("Interval out of range: %s", next)
+		}
+	}
+
+	// The jitter can't make the interval too short
+	conf = Config{Interval: time.Second, IntervalJitter: time.Second}
+	if nextInterval() != minInterval {
+		t.Errorf("Expected the minimum interval, got %s", nextInterval())
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Sends SIGUSR1 to c to trigger an immediate check.
+func notifyTriggerSignal(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
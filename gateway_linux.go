@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Route flags from linux/route.h
+const (
+	rtfUp      = 0x1
+	rtfGateway = 0x2
+)
+
+// Returns the IPv4 default gateway from /proc/net/route.
+func getDefaultGateway() (net.IP, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseRouteTable(bufio.NewScanner(file))
+}
+
+// Finds the default route in the format of /proc/net/route. The addresses are in hex, in host byte order.
+// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+//
+// Default routes without a gateway, like the one of a ppp0 link, are skipped since there is no router to ask.
+func parseRouteTable(scanner *bufio.Scanner) (net.IP, error) {
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&(rtfUp|rtfGateway) != rtfUp|rtfGateway {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 || binary.BigEndian.Uint32(raw) == 0 {
+			continue
+		}
+
+		gateway := make(net.IP, 4)
+		binary.NativeEndian.PutUint32(gateway, binary.BigEndian.Uint32(raw))
+		return gateway, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no default route")
+}
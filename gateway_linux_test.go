@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseRouteTable(t *testing.T) {
+	// The table below was taken from a little-endian host
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("Not a little-endian host")
+	}
+
+	table := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	0001A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	0	00000000	0	0	0
+`
+	gateway, err := parseRouteTable(bufio.NewScanner(strings.NewReader(table)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gateway.Equal(net.ParseIP("192.168.1.1")) {
+		t.Errorf("Unexpected gateway: %s", gateway)
+	}
+
+	// A point-to-point default route has no gateway. The next default route is used.
+	table = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+ppp0	00000000	00000000	0001	0	0	0	00000000	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+`
+	gateway, err = parseRouteTable(bufio.NewScanner(strings.NewReader(table)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gateway.Equal(net.ParseIP("192.168.1.1")) {
+		t.Errorf("Expected the route without a gateway to be skipped, got %s", gateway)
+	}
+
+	table = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+ppp0	00000000	00000000	0001	0	0	0	00000000	0	0	0
+`
+	if gateway, err := parseRouteTable(bufio.NewScanner(strings.NewReader(table))); err == nil {
+		t.Errorf("Expected an error when no default route has a gateway, got %s", gateway)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// Finding the default gateway is only supported on Linux. Set Gateway in the config file instead.
+func getDefaultGateway() (net.IP, error) {
+	return nil, errors.New("finding the default gateway is only supported on Linux")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The options for OnAddressLost, which decides what happens to a record when the device's address for its IP version can't be found.
+const (
+	// Leave the record as it is.
+	addressLostKeep string = "keep"
+	// Delete the record once the address has been missing for AddressLostAfter.
+	addressLostDelete string = "delete-after"
+	// Point the record to ReplaceWith once the address has been missing for AddressLostAfter.
+	addressLostReplace string = "replace-with"
+)
+
+// The time the address has to be missing before the OnAddressLost policy is applied, when AddressLostAfter is not set.
+// IP sources fail now and then, and a single failure shouldn't delete or repoint the records.
+const defaultAddressLostAfter time.Duration = time.Hour
+
+// Reports whether policy is one of the OnAddressLost options.
+func isValidAddressLostPolicy(policy string) bool {
+	switch policy {
+	case "", addressLostKeep, addressLostDelete, addressLostReplace:
+		return true
+	}
+	return false
+}
+
+// Returns the address in the record's ReplaceWith for the IP version, or nil if there isn't one.
+func (r *Record) replacementIP(version IPVersion) net.IP {
+	for _, address := range r.ReplaceWith {
+		if ip := net.ParseIP(address); ip != nil && version.matches(ip) {
+			return ip
+		}
+	}
+	return nil
+}
+
+// When the address of an IP version was first found to be missing. Saved next to the IP cache so that it survives between runs.
+type missingAddress struct {
+	// The first time that the address couldn't be found
+	Since time.Time `json:"Since"`
+	// If the OnAddressLost policy has already been applied
+	Handled bool `json:"Handled"`
+}
+
+// Applies the record's OnAddressLost policy after the device's address for the IP version couldn't be found.
+// Returns nil once the policy has been applied, and err while the record is kept as it is.
+// The address is the one the record points to after the policy was applied: the ReplaceWith address, or nil if there is no record.
+func handleAddressLost(record *Record, version IPVersion, err error) (net.IP, error) {
+	policy := record.onAddressLost()
+	if policy == addressLostKeep {
+		return nil, err
+	}
+
+	// Also read in dry-run mode, so that the plan only shows the policy once a real run would apply it
+	missing, readErr := getMissingAddress(record, version)
+	if readErr != nil {
+		if !errors.Is(readErr, os.ErrNotExist) {
+			log.WithFields(log.Fields{"err": readErr, "record": record.fqdn, "version": version}).Error("[handleAddressLost] Failed to read when the address went missing")
+		}
+		missing = missingAddress{Since: time.Now()}
+		setMissingAddress(record, version, missing)
+	}
+
+	if missing.Handled {
+		if policy == addressLostReplace {
+			return record.replacementIP(version), nil
+		}
+		return nil, nil
+	}
+
+	missingFor := time.Since(missing.Since)
+	fields := log.Fields{"record": record.fqdn, "version": version, "policy": policy, "missingSince": missing.Since, "after": record.addressLostAfter()}
+	if missingFor < record.addressLostAfter() {
+		log.WithFields(fields).Info("[handleAddressLost] The address is missing. Waiting before applying the policy")
+		return nil, err
+	}
+
+	log.WithFields(fields).Warn("[handleAddressLost] The address has been missing for too long. Applying the policy")
+
+	if err := ensureZoneID(record.zone); err != nil {
+		log.WithFields(log.Fields{"err": err, "domain": record.zone.Domain, "hint": cloudflareErrorHint(err)}).Error("[handleAddressLost] Failed to get the zone ID")
+		runErrorScript(record, err, version, nil, nil)
+		return nil, err
+	}
+
+	var published net.IP
+	switch policy {
+	case addressLostDelete:
+		err = deleteRecords(record, version)
+	case addressLostReplace:
+		replacement := record.replacementIP(version)
+		if replacement == nil {
+			log.WithFields(fields).Warn("[handleAddressLost] No ReplaceWith address for the IP version. Keeping the record")
+			return nil, err
+		}
+		err = updateRecordIP(record, version, replacement)
+		if err == nil {
+			published = replacement
+		} else if errors.Is(err, recordSkippedErr) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	missing.Handled = true
+	setMissingAddress(record, version, missing)
+	return published, nil
+}
+
+// Deletes the record's records for the IP version. Records without the ownership marker are not deleted.
+// The error script is called for any error returned.
+func deleteRecords(record *Record, version IPVersion) error {
+	records, err := getCurrentRecords(record, version)
+	if errors.Is(err, NoRecordFoundErr) {
+		log.WithFields(log.Fields{"record": record.fqdn, "version": version}).Info("[deleteRecords] There is no record to delete")
+		return nil
+	}
+
+	if err == nil {
+		for _, current := range records {
+			if _, err = checkOwnership(record, version, current); err != nil {
+				break
+			}
+
+			if err = deleteRecord(record, current); err != nil {
+				err = fmt.Errorf("Failed to delete the record. %w", err)
+				break
+			}
+		}
+	}
+
+	delete(record.recordIDs, version)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version}).Error("[deleteRecords] Failed to delete the record")
+		runErrorScript(record, err, version, nil, nil)
+		return err
+	}
+
+	// Only once every record is gone, since the address is still published otherwise
+	recordDeleted(record, version)
+	if !dryRun {
+		os.Remove(getCacheFilePath(record.fqdn, version.getRecordType()))
+	}
+	return nil
+}
+
+// Gets when the address for the IP version went missing.
+func getMissingAddress(record *Record, version IPVersion) (missingAddress, error) {
+	var missing missingAddress
+
+	data, err := os.ReadFile(getMissingFilePath(record.fqdn, version.getRecordType()))
+	if err != nil {
+		return missing, err
+	}
+
+	err = json.Unmarshal(data, &missing)
+	return missing, err
+}
+
+// Saves when the address for the IP version went missing. If it fails, the error gets logged.
+func setMissingAddress(record *Record, version IPVersion, missing missingAddress) {
+	if dryRun {
+		return
+	}
+
+	path := getMissingFilePath(record.fqdn, version.getRecordType())
+
+	jsonData, err := json.Marshal(missing)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version}).Error("[setMissingAddress] Failed to encode JSON")
+		return
+	}
+
+	// Everybody can RX, only owner can W
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.WithFields(log.Fields{"err": err, "path": path}).Error("[setMissingAddress] Failed to make directory")
+		return
+	}
+
+	if err := os.WriteFile(path, jsonData, 0664); err != nil {
+		log.WithFields(log.Fields{"err": err, "path": path}).Error("[setMissingAddress] Failed to save file")
+	}
+}
+
+// Forgets that the address for the IP version was missing, after it was found again.
+func clearMissingAddress(record *Record, version IPVersion) {
+	if dryRun {
+		return
+	}
+
+	err := os.Remove(getMissingFilePath(record.fqdn, version.getRecordType()))
+	if err == nil {
+		log.WithFields(log.Fields{"record": record.fqdn, "version": version}).Info("[clearMissingAddress] The address is back")
+	}
+}
+
+func getMissingFilePath(fqdn string, recordType string) string {
+	return filepath.Join(os.TempDir(), "ddns-cf-cache", fqdn+"-"+recordType+"-missing.json")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleAddressLostDelete(t *testing.T) {
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	var deleted []string
+	record := newMockRecord(t, "lost-delete", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "AAAA", "content": "2001:db8::1"}]}`))
+		case "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+		}
+	})
+	record.OnAddressLost = addressLostDelete
+	record.AddressLostAfter = time.Hour
+	clearMissingAddress(record, IPv6)
+	defer clearMissingAddress(record, IPv6)
+
+	lostErr := errors.New("no IPv6 address")
+
+	// The address just went missing
+	if _, err := handleAddressLost(record, IPv6, lostErr); err != lostErr {
+		t.Errorf("Expected the error to be returned while waiting, got %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("Expected no deletes before AddressLostAfter, got %v", deleted)
+	}
+
+	// The address has been missing for longer than AddressLostAfter
+	setMissingAddress(record, IPv6, missingAddress{Since: time.Now().Add(-2 * time.Hour)})
+	if _, err := handleAddressLost(record, IPv6, lostErr); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/zones/zone123/dns_records/rec1" {
+		t.Errorf("Expected rec1 to be deleted, got %v", deleted)
+	}
+
+	// The policy is only applied once
+	if _, err := handleAddressLost(record, IPv6, lostErr); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Expected a single delete, got %v", deleted)
+	}
+}
+
+func TestHandleAddressLostReplace(t *testing.T) {
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	var patches []map[string]any
+	record := newMockRecord(t, "lost-replace", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "AAAA", "content": "2001:db8::1"}]}`))
+		case "PATCH":
+			var patch map[string]any
+			json.NewDecoder(r.Body).Decode(&patch)
+			patches = append(patches, patch)
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+		}
+	})
+	record.OnAddressLost = addressLostReplace
+	record.ReplaceWith = []string{"192.0.2.1", "2001:db8::ffff"}
+	clearMissingAddress(record, IPv6)
+	defer clearMissingAddress(record, IPv6)
+
+	// A single failed check doesn't change the record when AddressLostAfter is not set
+	lostErr := errors.New("no IPv6 address")
+	if _, err := handleAddressLost(record, IPv6, lostErr); err != lostErr {
+		t.Errorf("Expected the error to be returned while waiting, got %v", err)
+	}
+	if len(patches) != 0 {
+		t.Fatalf("Expected no changes before the default AddressLostAfter, got %v", patches)
+	}
+
+	setMissingAddress(record, IPv6, missingAddress{Since: time.Now().Add(-defaultAddressLostAfter)})
+	published, err := handleAddressLost(record, IPv6, lostErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0]["content"] != "2001:db8::ffff" {
+		t.Errorf("Expected the record to be pointed to the IPv6 ReplaceWith address, got %v", patches)
+	}
+	if !published.Equal(net.ParseIP("2001:db8::ffff")) {
+		t.Errorf("Expected the ReplaceWith address to be reported as published, got %s", published)
+	}
+
+	// It is still reported once the policy has been applied
+	if published, err := handleAddressLost(record, IPv6, lostErr); err != nil || !published.Equal(net.ParseIP("2001:db8::ffff")) {
+		t.Errorf("Expected the ReplaceWith address to be reported as published, got %s %v", published, err)
+	}
+}
+
+func TestUpdateRecordIPDisableCreate(t *testing.T) {
+	disabled := true
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	record := newMockRecord(t, "no-create", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"success": true, "result": []}`))
+	})
+	record.DisableCreate = &disabled
+
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.1")); !errors.Is(err, recordSkippedErr) {
+		t.Errorf("Expected recordSkippedErr, got %v", err)
+	}
+}
+
+// A record that couldn't be deleted is still published
+func TestDeleteRecordsNotOwned(t *testing.T) {
+	conf = Config{DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	record := newMockRecord(t, "lost-not-owned", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "AAAA", "content": "2001:db8::1", "comment": "set by hand"}]}`))
+	})
+	recordSucceeded(record, IPv6, net.ParseIP("2001:db8::1"))
+
+	if err := deleteRecords(record, IPv6); !errors.Is(err, notOwnedErr) {
+		t.Errorf("Expected notOwnedErr, got %v", err)
+	}
+
+	var out strings.Builder
+	publishedIP.write(&out)
+	if !strings.Contains(out.String(), `record="lost-not-owned.example.com",type="AAAA",ip="2001:db8::1"`) {
+		t.Errorf("Expected the address to still be published:\n%s", out.String())
+	}
+}
+
+// The plan only shows the policy once a real run would apply it
+func TestHandleAddressLostDryRun(t *testing.T) {
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true}
+	defer func() {
+		conf = Config{}
+		dryRun = false
+	}()
+
+	record := newMockRecord(t, "lost-plan", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Unexpected request in dry-run mode: %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "AAAA", "content": "2001:db8::1"}]}`))
+	})
+	record.OnAddressLost = addressLostDelete
+	clearMissingAddress(record, IPv6)
+	defer clearMissingAddress(record, IPv6)
+
+	lostErr := errors.New("no IPv6 address")
+	dryRun = true
+	out := captureStdout(t, func() {
+		if _, err := handleAddressLost(record, IPv6, lostErr); err != lostErr {
+			t.Errorf("Expected the error to be returned while waiting, got %v", err)
+		}
+	})
+	if out != "" {
+		t.Errorf("Expected nothing to be planned while waiting, got %q", out)
+	}
+	if _, err := getMissingAddress(record, IPv6); err == nil {
+		t.Error("Expected the state file not to be written in dry-run mode")
+	}
+
+	dryRun = false
+	setMissingAddress(record, IPv6, missingAddress{Since: time.Now().Add(-2 * defaultAddressLostAfter)})
+	dryRun = true
+	out = captureStdout(t, func() {
+		if _, err := handleAddressLost(record, IPv6, lostErr); err != nil {
+			t.Error(err)
+		}
+	})
+	if strings.TrimSpace(out) != "- AAAA  lost-plan.example.com  2001:db8::1" {
+		t.Errorf("Unexpected plan: %q", out)
+	}
+}
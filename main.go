@@ -1,19 +1,20 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/Jeffail/gabs"
+	"mtzfederico/ddns-cf/cloudflare"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -25,342 +26,590 @@ import (
 var BuildInfo string
 
 var (
-	NoRecordFoundErr                 = errors.New("domain/subdomain does not exist")
-	noIPAddressFoundErr              = errors.New("No IP address found")
-	invalidIPAddressErr              = errors.New("Invalid IPAddress")
-	invalidIPVersionErr              = errors.New("Invalid IP Version")
-	FailedToDecodeJSONErr            = errors.New("failed to decode JSON")
-	failedToParseRecordIDFromJSON    = errors.New("failed to parse the record's ID from JSON")
-	failedToParseRecordValueFromJSON = errors.New("failed to parse the record's value from JSON")
+	NoRecordFoundErr      = errors.New("domain/subdomain does not exist")
+	noIPAddressFoundErr   = errors.New("No IP address found")
+	invalidIPAddressErr   = errors.New("Invalid IPAddress")
+	invalidIPVersionErr   = errors.New("Invalid IP Version")
+	FailedToDecodeJSONErr = errors.New("failed to decode JSON")
+	duplicateRecordsErr   = errors.New("more than one record with the same name and type")
+	// Returned by updateRecordIP when the record doesn't exist and DisableCreate is set. Nothing was published, but it isn't a failure.
+	recordSkippedErr = errors.New("the record doesn't exist and DisableCreate is set")
+)
+
+const (
+	UserAgent string = "ddns-cf/1.1 (github.com/mtzfederico/ddns-cf)"
 )
 
+// Exit codes that report the outcome of a run.
 const (
-	cfApiBaseURL string = "https://api.cloudflare.com/client/v4/"
-	UserAgent    string = "ddns-cf/1.1 (github.com/mtzfederico/ddns-cf)"
+	exitOK int = 0
+	// Used by log.Fatal. The config file is missing or invalid.
+	exitConfigError int = 1
+	// Some of the records could not be checked or updated.
+	exitPartialFailure int = 2
+	// None of the records could be checked or updated.
+	exitFailure int = 3
+	// The run was stopped by SIGTERM or SIGINT before it finished.
+	exitStopped int = 4
 )
 
 var conf Config
 var httpClient *http.Client
 
-type RecordData struct {
-	Type    string `json:"type" binding:"required"`
-	Name    string `json:"name" binding:"required"`
-	Content string `json:"content" binding:"required"`
-	TTL     int    `json:"ttl" binding:"required"`
-	Proxied bool   `json:"proxied" binding:"required"`
+// Passed to every Cloudflare API request and IP source. Cancelled on SIGTERM or SIGINT, so that the run stops
+// instead of waiting for requests and retries.
+var runContext = context.Background()
+
+// Reports whether the run was stopped by SIGTERM or SIGINT. The errors it causes are not failures of the records,
+// so they don't run the error script or send notifications.
+func runAborted() bool {
+	return runContext.Err() != nil
 }
 
-func sendRequest(path string, method string, requestBody []byte) *gabs.Container {
-	url := cfApiBaseURL + path
-	// fmt.Printf("%s%s %s%s\n", color.Yellow, method, url, color.Reset)
-	log.WithFields(log.Fields{"method": method, "url": url}).Trace(("[sendRequest] Sending request"))
+// The Cloudflare clients for each API token. Set by getCloudflareClient.
+var cfClients = make(map[string]*cloudflare.Client)
 
-	var req *http.Request
-	var err error
-	if requestBody != nil {
-		requestData := bytes.NewBuffer(requestBody)
-		req, err = http.NewRequest(method, url, requestData)
-	} else {
-		req, err = http.NewRequest(method, url, nil)
+// Returns the Cloudflare client for the API token. Zones that use the same token share a client, and its rate limit.
+func getCloudflareClient(apiKey string) *cloudflare.Client {
+	if client, found := cfClients[apiKey]; found {
+		return client
 	}
 
-	if err != nil {
-		log.Fatal("Error creating Request: ", err)
+	client := cloudflare.NewClient(apiKey)
+	client.HTTPClient = httpClient
+	client.OnRequest = observeAPIRequest
+	client.UserAgent = UserAgent
+	if conf.APIBaseURL != "" {
+		client.BaseURL = conf.APIBaseURL
 	}
 
-	req.Header.Set("Authorization", "Bearer "+conf.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", UserAgent)
-
-	resp, err := httpClient.Do(req)
-
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("[sendRequest] httpClient error")
+	switch {
+	case conf.APIRetries < 0:
+		client.Retries = 0
+	case conf.APIRetries > 0:
+		client.Retries = conf.APIRetries
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalln(err)
+	if conf.APIRetryDelay > 0 {
+		client.RetryDelay = conf.APIRetryDelay
 	}
-
-	// fmt.Printf("%s%s%s", color.Ize(color.Blue, "----- Response Starts -----\n"), string(body), color.Ize(color.Blue, "\n----- Response Ends -----\n"))
-	log.WithFields(log.Fields{"responseBody": string(body)}).Trace(("[sendRequest] Received response"))
-
-	jsonParsed, err := gabs.ParseJSON(body)
-
-	if err != nil {
-		log.WithFields(log.Fields{"path": path, "method": method, "responseBody": string(body)}).Fatal(("[sendRequest] Failed to parse JSON"))
+	if conf.APIMaxRetryDelay > 0 {
+		client.MaxRetryDelay = conf.APIMaxRetryDelay
 	}
 
-	return jsonParsed
+	cfClients[apiKey] = client
+	return client
 }
 
-func getIP(ipVersion IPVersion) (net.IP, error) {
-	url := "https://ip" + string(ipVersion) + ".icanhazip.com"
-
-	req, err := http.NewRequest("GET", url, nil)
+func getZoneID(zone *Zone) (string, error) {
+	// Get domain's zone id. data.result[0].id
+	// https://api.cloudflare.com/#zone-list-zones
+	zoneID, err := zone.client.GetZoneID(runContext, zone.Domain)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "ipVersion": ipVersion}).Fatal("[getIP] Error creating request")
+		return "", err
 	}
 
-	req.Header.Set("User-Agent", UserAgent)
-
-	resp, err := httpClient.Do(req)
+	log.WithFields(log.Fields{"domain": zone.Domain, "zoneID": zoneID}).Debug("[getZoneID] Got zoneID from CF")
+	return zoneID, nil
+}
 
-	if err != nil {
-		log.WithFields(log.Fields{"error": err, "ipVersion": ipVersion}).Fatal("[getIP] Error sending request")
-		return nil, fmt.Errorf("Error sending get IP%s request: %w", string(ipVersion), err)
+// Fetches the zone's ID from Cloudflare if it isn't in the config file.
+func ensureZoneID(zone *Zone) error {
+	if zone.DomainZoneID != "" {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	log.WithField("domain", zone.Domain).Info("ZoneID not in config file, fetching from CF.")
+	zoneID, err := getZoneID(zone)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "resp": resp}).Fatal("[getIP] Error processing response")
+		return err
 	}
-
-	// TODO: remove other chars such as space. check if parseip needs this
-	addrStr := strings.TrimSuffix(string(body), "\n")
-
-	if addrStr == "" {
-		return nil, noIPAddressFoundErr
-	}
-
-	// The main reason for this is to make it easier to compare values, specially for v6 since it can be in different formats.
-	address := net.ParseIP(addrStr)
-	if address == nil {
-		return nil, invalidIPAddressErr
-	}
-
-	return address, nil
-}
-
-func getZoneID() string {
-	// Get domain's zone id. data.result[0].id
-	// https://api.cloudflare.com/#zone-list-zones
-	url := "zones?name=" + conf.Domain
-	resp := sendRequest(url, "GET", nil)
-	zoneID, ok := resp.S("result").Index(0).Path("id").Data().(string)
-	if !ok {
-		log.WithFields(log.Fields{"resp": resp}).Error("[getZoneID] Error decoding zoneID")
-	}
-	log.WithFields(log.Fields{"zoneID": zoneID}).Debug("[getZoneID] Got zoneID from CF")
-	return zoneID
+	zone.DomainZoneID = zoneID // save for later use but don't save to file
+	return nil
 }
 
-// Get the domain's current value for the specified record type (A, AAAA, TXT, etc.)
+// Get the record's current records for the specified IP version (A or AAAA records).
+// There is usually only one, but a name can have more than one record of the same type.
 //
-// Returns Value, recordID, error.
-// returns "" when there is no value
-func getCurrentValue(version IPVersion) (net.IP, string, error) {
+// Returns NoRecordFoundErr when there are none.
+func getCurrentRecords(record *Record, version IPVersion) ([]cloudflare.DNSRecord, error) {
 	recordType := version.getRecordType()
 	if recordType == "" {
-		return nil, "", invalidIPVersionErr
+		return nil, invalidIPVersionErr
 	}
+
 	// https://api.cloudflare.com/#dns-records-for-a-zone-list-dns-records
 	// name is the FQDN. 'subdomain.domain.tld' or 'domain.tld'
-	zoneID := conf.DomainZoneID
-
-	if zoneID == "" {
-		log.Info("ZoneID not in config file, fetching from CF.")
-		zoneID = getZoneID()
-		conf.DomainZoneID = zoneID // save for later use but don't save to file
+	params := cloudflare.ListDNSRecordsParams{Type: recordType, Name: record.fqdn}
+	records, err := record.zone.client.ListDNSRecords(runContext, record.zone.DomainZoneID, params)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "record": record.fqdn}).Error("[getCurrentRecords] API call failed")
+		return nil, err
 	}
-	path := "zones/" + zoneID + "/dns_records?type=" + recordType + "&name=" + conf.name
-	resp := sendRequest(path, "GET", nil)
 
-	success, ok := resp.Path("success").Data().(bool)
-
-	if !ok {
-		return nil, "", FailedToDecodeJSONErr
+	// the subdomain exists but there is no record for this type. There is an A record but no AAAA record or vice versa.
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: no record of type %s for %s", NoRecordFoundErr, recordType, record.fqdn)
 	}
 
-	if !success {
-		log.WithFields(log.Fields{"resp": resp}).Error("[getCurrentValue] API call failed")
-		error := resp.S("errors").Index(0)
-		errorCode := error.Path("code").Data().(string)
-		if errorCode == "7003" {
-			return nil, "", NoRecordFoundErr
+	for _, current := range records {
+		// The id of the actual A or AAAA record, needed to update it.
+		if current.ID == "" {
+			return nil, fmt.Errorf("no recordID for %s's %s record", record.fqdn, recordType)
 		}
-		return nil, "", fmt.Errorf("errorCode %s: %s", errorCode, error.Path("message").Data().(string))
 	}
 
-	result := resp.S("result")
-	resultLen, err := result.ArrayCount()
+	return records, nil
+}
 
-	if err != nil {
-		return nil, "", fmt.Errorf("Failed to get length of result: %w", err)
+// Applies the record's DuplicateRecords policy when there is more than one record of the same type.
+// Returns the records that should be kept up to date. IP is the address the records should point to.
+func resolveDuplicates(record *Record, version IPVersion, records []cloudflare.DNSRecord, IP net.IP) ([]cloudflare.DNSRecord, error) {
+	if len(records) < 2 {
+		return records, nil
 	}
 
-	// the subdomain exists but there is no record for this type. There is an A record but no AAAA record or vice versa.
-	if resultLen == 0 {
-		return nil, "", fmt.Errorf("no record of type %s for %s", recordType, conf.name)
-	}
+	policy := record.duplicateRecords()
+	log.WithFields(log.Fields{"record": record.fqdn, "version": version, "count": len(records), "policy": policy}).Warn("[resolveDuplicates] More than one record found")
 
-	content, ok := result.Index(0).Path("content").Data().(string) // The record's value
-	if !ok {
-		return nil, "", failedToParseRecordValueFromJSON
-	}
+	switch policy {
+	case duplicatesUpdateAll:
+		return records, nil
+	case duplicatesUpdateOne:
+		// Nothing is deleted unless every record can be touched. Otherwise the record that is kept could be one that can't be updated.
+		for _, current := range records {
+			if _, err := checkOwnership(record, version, current); err != nil {
+				return nil, err
+			}
+		}
 
-	if content == "" {
-		return nil, "", fmt.Errorf("no Content for %s's %s record", conf.name, recordType)
+		// Keep the record that already points to IP, so that a run with nothing to change doesn't change anything
+		keep := 0
+		for i, current := range records {
+			if net.ParseIP(current.Content).Equal(IP) {
+				keep = i
+				break
+			}
+		}
 
-	}
+		for i, extra := range records {
+			if i == keep {
+				continue
+			}
 
-	RecordID, ok := result.Index(0).Path("id").Data().(string) // The id of the actual A or AAAA record, needed to update it.
-	if !ok {
-		return nil, "", failedToParseRecordIDFromJSON
+			err := deleteRecord(record, extra)
+			if err != nil && !errors.Is(err, cloudflare.ErrRecordNotFound) {
+				return nil, fmt.Errorf("Failed to delete the duplicate record %s. %w", extra.ID, err)
+			}
+		}
+		return records[keep : keep+1], nil
+	default:
+		return nil, fmt.Errorf("%w: %d %s records for %s", duplicateRecordsErr, len(records), version.getRecordType(), record.fqdn)
 	}
+}
 
-	if RecordID == "" {
-		return nil, "", fmt.Errorf("no recordID for %s's %s record", conf.name, recordType)
+// Update the IP Address of the current record.
+// Only the content is changed, unless the record enforces its TTL or proxy status. Comments and tags are kept.
+// If adopt is true, the ownership marker is added to the record.
+func updateRecord(record *Record, current cloudflare.DNSRecord, IP net.IP, adopt bool) error {
+	// https://developers.cloudflare.com/api/operations/dns-records-for-a-zone-patch-dns-record
+	patch := cloudflare.DNSRecordPatch{Content: ipToString(IP)}
+	if record.enforceTTL() {
+		patch.TTL = record.ttl()
+	}
+	if record.enforceProxied() {
+		proxied := record.isProxied()
+		patch.Proxied = &proxied
+	}
+	if adopt {
+		if err := stampExistingRecord(record, current, &patch); err != nil {
+			return err
+		}
 	}
 
-	recordValue := net.ParseIP(content)
+	if dryRun {
+		details := []string{current.Content, "->", patch.Content}
+		if patch.TTL != 0 && patch.TTL != current.TTL {
+			details = append(details, fmt.Sprintf("ttl=%d->%d", current.TTL, patch.TTL))
+		}
+		if patch.Proxied != nil && *patch.Proxied != current.Proxied {
+			details = append(details, fmt.Sprintf("proxied=%t->%t", current.Proxied, *patch.Proxied))
+		}
+		if adopt {
+			details = append(details, "(adopt)")
+		}
+		printPlan(planUpdate, current.Type, record.fqdn, details...)
+		return nil
+	}
 
-	return recordValue, RecordID, nil
+	_, err := record.zone.client.PatchDNSRecord(runContext, record.zone.DomainZoneID, current.ID, patch)
+	if err != nil {
+		return fmt.Errorf("Failed to update the record. %w", err)
+	}
+	log.WithFields(log.Fields{"record": record.fqdn, "recordType": current.Type, "recordID": current.ID}).Info("record changed successfully")
+	return nil
 }
 
-// Update the IP Address of recordID specified.
-func updateRecord(recordID string, recordType string, IP net.IP) error {
-	// https://api.cloudflare.com/#dns-records-for-a-zone-update-dns-record
-	path := "zones/" + conf.DomainZoneID + "/dns_records/" + recordID
-	ttl := conf.RecordTTL
-	if ttl == 0 {
-		ttl = 1 // 1 is Automatic
+// Deletes a record. In dry-run mode, the deletion is only printed.
+func deleteRecord(record *Record, current cloudflare.DNSRecord) error {
+	if dryRun {
+		printPlan(planDelete, current.Type, record.fqdn, current.Content)
+		return nil
 	}
 
-	var requestBody RecordData
-	requestBody.Type = recordType
-	requestBody.Name = conf.name
-	requestBody.Content = ipToString(IP)
-	requestBody.TTL = ttl
-	requestBody.Proxied = conf.IsProxied
-
-	requestData, _ := json.Marshal((requestBody))
-	resp := sendRequest(path, "PUT", requestData)
-
-	success, ok := resp.S("success").Data().(bool)
-	if !ok {
-		log.WithFields(log.Fields{"resp": resp}).Error("[updateRecord] Error decoding response")
+	err := record.zone.client.DeleteDNSRecord(runContext, record.zone.DomainZoneID, current.ID)
+	if err != nil {
+		return err
 	}
 
-	if !success {
-		errorMessage, _ := resp.S("errors").Index(0).Path("message").Data().(string)
-		return fmt.Errorf("Failed to update the record. %s", errorMessage)
-	}
-	log.WithFields(log.Fields{"recordType": recordType}).Info("record changed successfully")
+	log.WithFields(log.Fields{"record": record.fqdn, "recordType": current.Type, "recordID": current.ID, "content": current.Content}).Info("record deleted successfully")
 	return nil
 }
 
-func createRecord(recordType string, IP string) error {
+// Creates the record with the IP specified. Returns the new record's ID.
+func createRecord(record *Record, recordType string, IP string) (string, error) {
 	// https://api.cloudflare.com/#dns-records-for-a-zone-create-dns-record
-	path := "zones/" + conf.DomainZoneID + "/dns_records"
-	ttl := conf.RecordTTL
-	if ttl == 0 {
-		ttl = 1 // 1 is Automatic
+	requestBody := cloudflare.DNSRecord{
+		Type:    recordType,
+		Name:    record.fqdn,
+		Content: IP,
+		TTL:     record.ttl(),
+		Proxied: record.isProxied(),
+	}
+	stampNewRecord(&requestBody)
+
+	if dryRun {
+		printPlan(planCreate, recordType, record.fqdn, IP)
+		return "", createOwnerTXT(record)
+	}
+
+	created, err := record.zone.client.CreateDNSRecord(runContext, record.zone.DomainZoneID, requestBody)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create the record. %w", err)
+	}
+
+	// Without it, the record would be skipped on the next check
+	if err := createOwnerTXT(record); err != nil {
+		return created.ID, err
 	}
 
-	var requestBody RecordData
-	requestBody.Type = recordType
-	requestBody.Name = conf.name
-	requestBody.Content = IP
-	requestBody.TTL = ttl
-	requestBody.Proxied = conf.IsProxied
+	log.WithFields(log.Fields{"record": record.fqdn, "recordType": recordType, "IP": IP, "recordID": created.ID}).Info("record created successfully")
+	return created.ID, nil
+}
 
-	requestData, _ := json.Marshal((requestBody))
-	resp := sendRequest(path, "POST", requestData)
+// The outcome of checking and updating the records.
+type runResult struct {
+	// The amount of records that are up to date
+	succeeded int
+	// The amount of records that could not be checked or updated
+	failed int
+}
 
-	success, ok := resp.S("success").Data().(bool)
-	if !ok {
-		log.Error("[createRecord] Error decoding response")
+func (r *runResult) add(err error) {
+	if err != nil {
+		r.failed++
+	} else {
+		r.succeeded++
 	}
+}
 
-	if !success {
-		errorMessage, _ := resp.S("errors").Index(0).Path("message").Data().(string)
-		return fmt.Errorf("Failed to create the record. %s", errorMessage)
+func (r *runResult) merge(other runResult) {
+	r.succeeded += other.succeeded
+	r.failed += other.failed
+}
+
+// Returns the process exit code for the outcome.
+func (r runResult) exitCode() int {
+	switch {
+	case r.failed == 0:
+		return exitOK
+	case r.succeeded == 0:
+		return exitFailure
+	default:
+		return exitPartialFailure
 	}
-	log.WithFields(log.Fields{"recordType": recordType, "IP": IP}).Info("record created successfully")
-	return nil
 }
 
-func updateIP(version IPVersion) {
-	recordType := version.getRecordType()
+// Detects the device's public address for the IP version once and updates every record that manages it.
+func updateIP(version IPVersion) runResult {
+	var result runResult
 
 	// The device's public address
 	IP, err := getIP(version)
+	if runAborted() {
+		return result
+	}
 	if err != nil {
 		// fmt.Printf("%sNo IP%s address found%s\n", color.Red, IPversion, color.Red)
 		log.WithFields(log.Fields{"version": version, "error": err}).Error("getIP Failed")
-		return
+		notify(eventDetectionFailure, nil, version, nil, nil, err)
+
+		forEachRecord(version, func(record *Record) {
+			if errors.Is(err, noConsensusErr) {
+				// Nothing gets updated, but the disagreement could mean that one of the sources is compromised
+				result.add(err)
+				state.recordChecked(record, version, nil, nil, err)
+				runErrorScript(record, err, version, nil, nil)
+				return
+			}
+			published, lostErr := handleAddressLost(record, version, err)
+			if lostErr == nil && published != nil {
+				recordSucceeded(record, version, published)
+			}
+			result.add(lostErr)
+			state.recordChecked(record, version, nil, published, lostErr)
+		})
+		return result
+	}
+
+	// A misconfigured source or a captive portal can return an address that can't be reached from the internet
+	if err := validatePublicIP(IP); err != nil {
+		if errors.Is(err, cgnatIPErr) {
+			log.WithFields(log.Fields{"version": version, "IP": IP}).Error("[updateIP] The ISP uses carrier-grade NAT (CGNAT). The device can't be reached from the internet with this address, so it won't be published. Ask the ISP for a public address or use IPv6")
+		} else {
+			log.WithFields(log.Fields{"version": version, "IP": IP, "error": err}).Error("[updateIP] The detected address is not public. It won't be published")
+		}
+
+		forEachRecord(version, func(record *Record) {
+			result.add(err)
+			state.recordChecked(record, version, IP, nil, err)
+			runErrorScript(record, err, version, nil, IP)
+		})
+		return result
+	}
+
+	forEachRecord(version, func(record *Record) {
+		clearMissingAddress(record, version)
+	})
+
+	// Each zone is handled on its own so that an error in one of them doesn't stop the others from being updated.
+	for _, zone := range conf.Zones {
+		if runAborted() {
+			break
+		}
+		if err := ensureZoneID(zone); err != nil {
+			log.WithFields(log.Fields{"err": err, "domain": zone.Domain, "version": version, "hint": cloudflareErrorHint(err)}).Error("[updateIP] Failed to get the zone ID. Skipping zone")
+			for _, record := range zone.Records {
+				if record.manages(version) {
+					result.add(err)
+					state.recordChecked(record, version, IP, nil, err)
+					runErrorScript(record, err, version, nil, IP)
+				}
+			}
+			continue
+		}
+
+		for _, record := range zone.Records {
+			if !record.manages(version) {
+				continue
+			}
+			if runAborted() {
+				break
+			}
+			published := record.addressFor(version, IP)
+			err := updateRecordIP(record, version, published)
+			if errors.Is(err, recordSkippedErr) {
+				// There is no record, so there is no published address to report
+				err, published = nil, nil
+				recordDeleted(record, version)
+			} else if err == nil {
+				recordSucceeded(record, version, published)
+			}
+			state.recordChecked(record, version, IP, published, err)
+			result.add(err)
+		}
+	}
+
+	return result
+}
+
+// Calls fn for every record in every zone that manages the IP version.
+func forEachRecord(version IPVersion, fn func(record *Record)) {
+	for _, zone := range conf.Zones {
+		for _, record := range zone.Records {
+			if record.manages(version) {
+				fn(record)
+			}
+		}
 	}
+}
 
-	if !conf.DisableCFCache {
-		cachedIP, err := getCachedIP(version)
+// Makes sure that the record for the IP version points to IP, creating it if needed.
+// Returns recordSkippedErr if it doesn't exist and can't be created. The error script is called for any other error returned.
+func updateRecordIP(record *Record, version IPVersion, IP net.IP) error {
+	var records []cloudflare.DNSRecord
+	var err error
+
+	// The current value is always fetched in dry-run mode, to show what would change
+	if !conf.DisableCFCache && !dryRun {
+		cachedIP, err := getCachedIP(record, version)
 
 		if err == nil {
 			// If the chahe is less than 3 hours old, use it.
 			if time.Since(cachedIP.Time) < 3*time.Hour {
 				if IP.Equal(cachedIP.IPAddress) {
+					cacheHits.add(1)
 					// This would only NOT trigger a change if the IP has been changed in CF and the actual IP has not changed.
-					log.WithFields(log.Fields{"version": version, "ip": IP}).Info("IP address has not changed. Cache used")
-					return
+					log.WithFields(log.Fields{"record": record.fqdn, "version": version, "ip": IP}).Info("IP address has not changed. Cache used")
+					return nil
+				}
+
+				// The record ID is known from a previous check (daemon mode), no need to fetch the record again.
+				// Its ownership was checked when the ID was stored.
+				if id := record.recordIDs[version]; id != "" {
+					records = []cloudflare.DNSRecord{{ID: id, Type: version.getRecordType(), Content: ipToString(cachedIP.IPAddress)}}
 				}
 			} else {
-				log.WithField("cachedIPTime", cachedIP.Time).Debug("IP Cache Expired")
+				log.WithFields(log.Fields{"record": record.fqdn, "cachedIPTime": cachedIP.Time}).Debug("IP Cache Expired")
 			}
 		} else {
-			log.WithFields(log.Fields{"error": err, "version": version}).Error("[updateIP] Failed to get cache.")
+			log.WithFields(log.Fields{"error": err, "record": record.fqdn, "version": version}).Error("[updateRecordIP] Failed to get cache.")
 		}
+		cacheMisses.add(1)
 	}
 
-	domainIP, recordID, err := getCurrentValue(version)
+	fromCache := records != nil
+	if !fromCache {
+		records, err = getCurrentRecords(record, version)
+	}
 
 	// The record doesn't exist. Create it with the current IP
-	if err != nil && domainIP == nil && recordID == "" {
-		// create the record
-		// fmt.Printf("%sIP%s address detected for the first time: %s%s\n", color.Purple, IPversion, color.Reset, IP)
-		log.WithFields(log.Fields{"version": version, "IP": IP}).Info("IP address detected for the first time")
-		err = createRecord(recordType, ipToString(IP))
-		if err != nil {
-			log.WithFields(log.Fields{"err": err, "version": version, "domainIP": domainIP}).Error("[updateIP] Error creating domain record")
-			runErrorScript(err, version, domainIP, IP)
-			return
+	if errors.Is(err, NoRecordFoundErr) {
+		if record.disableCreate() {
+			log.WithFields(log.Fields{"record": record.fqdn, "version": version}).Warn("[updateRecordIP] The record doesn't exist and DisableCreate is set. Skipping it")
+			return recordSkippedErr
 		}
-		runUpdateScript(version, domainIP, IP)
-		setCachedIP(IP, version)
-		return
+
+		// fmt.Printf("%sIP%s address detected for the first time: %s%s\n", color.Purple, IPversion, color.Reset, IP)
+		log.WithFields(log.Fields{"record": record.fqdn, "version": version, "IP": IP}).Info("IP address detected for the first time")
+		return createRecordIP(record, version, IP)
+	}
+
+	if err == nil {
+		records, err = resolveDuplicates(record, version, records, IP)
 	}
 
 	if err != nil {
-		log.WithFields(log.Fields{"err": err, "version": version, "domainIP": domainIP, "recordID": recordID}).Info("[updateIP] Error getting the domain's record")
-		return
+		log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version, "hint": cloudflareErrorHint(err)}).Error("[updateRecordIP] Error getting the domain's record")
+		runErrorScript(record, err, version, nil, IP)
+		return err
 	}
 
-	if !domainIP.Equal(IP) {
-		// fmt.Printf("%sIP%s address changed: %s%s %s->%s %s\n", color.Purple, IPversion, color.Reset, domainIP, color.Purple, color.Reset, IP)
-		log.WithFields(log.Fields{"version": version, "from": domainIP, "to": IP}).Info("IP address changed")
-		err = updateRecord(recordID, recordType, IP)
+	var oldIP net.IP
+	for _, current := range records {
+		domainIP := net.ParseIP(current.Content)
+
+		var adopt bool
+		if !fromCache {
+			adopt, err = checkOwnership(record, version, current)
+			if err != nil {
+				if !errors.Is(err, notOwnedErr) && !errors.Is(err, ownedByOtherErr) {
+					log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version}).Error("[updateRecordIP] Failed to check the record's ownership")
+				}
+				delete(record.recordIDs, version)
+				runErrorScript(record, err, version, domainIP, IP)
+				return err
+			}
+		}
+
+		ipChanged := !domainIP.Equal(IP)
+		if !ipChanged && !record.settingsDiffer(current) && !adopt {
+			continue
+		}
+
+		if ipChanged {
+			// fmt.Printf("%sIP%s address changed: %s%s %s->%s %s\n", color.Purple, IPversion, color.Reset, domainIP, color.Purple, color.Reset, IP)
+			log.WithFields(log.Fields{"record": record.fqdn, "version": version, "recordID": current.ID, "from": domainIP, "to": IP}).Info("IP address changed")
+		} else if record.settingsDiffer(current) {
+			log.WithFields(log.Fields{"record": record.fqdn, "version": version, "recordID": current.ID, "TTL": current.TTL, "proxied": current.Proxied}).Info("Record TTL or proxy status changed. Setting it back")
+		}
+		err = updateRecord(record, current, IP, adopt)
+		if errors.Is(err, cloudflare.ErrRecordNotFound) && len(records) == 1 {
+			// The record was deleted since its ID was fetched
+			log.WithFields(log.Fields{"record": record.fqdn, "version": version, "recordID": current.ID}).Warn("[updateRecordIP] The record no longer exists. Creating it")
+			delete(record.recordIDs, version)
+			return createRecordIP(record, version, IP)
+		}
 		if err != nil {
-			log.WithFields(log.Fields{"err": err, "version": version, "domainIP": domainIP}).Error("[updateIP] Error updating domain record")
-			runErrorScript(err, version, domainIP, IP)
-			return
+			log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version, "domainIP": domainIP}).Error("[updateRecordIP] Error updating domain record")
+			// The record might have been deleted or changed, fetch it again on the next check.
+			delete(record.recordIDs, version)
+			runErrorScript(record, err, version, domainIP, IP)
+			return err
+		}
+
+		if ipChanged && oldIP == nil {
+			oldIP = domainIP
 		}
-		runUpdateScript(version, domainIP, IP)
-		setCachedIP(IP, version)
-		return
+	}
+
+	// Only a single record can be reused without listing them again.
+	if len(records) == 1 {
+		record.recordIDs[version] = records[0].ID
+	} else {
+		delete(record.recordIDs, version)
+	}
+
+	if oldIP != nil {
+		ipChanges.add(1, record.fqdn, version.getRecordType())
+		runUpdateScript(record, version, oldIP, IP)
+		setCachedIP(record, IP, version)
+		return nil
 	}
 
 	// fmt.Printf("%sIP%s address has not changed: %s%s\n", color.Green, IPversion, color.Reset, IP)
-	log.WithFields(log.Fields{"version": version, "ip": IP}).Info("IP address has not changed")
+	log.WithFields(log.Fields{"record": record.fqdn, "version": version, "ip": IP}).Info("IP address has not changed")
+	if dryRun {
+		printPlan(planUnchanged, version.getRecordType(), record.fqdn, ipToString(IP))
+	}
 	// refresh the cache's time if it has not changed
-	setCachedIP(domainIP, version)
+	setCachedIP(record, IP, version)
+	return nil
+}
+
+// Creates the record for the IP version pointing to IP.
+// The error script is called for any error returned.
+func createRecordIP(record *Record, version IPVersion, IP net.IP) error {
+	recordID, err := createRecord(record, version.getRecordType(), ipToString(IP))
+	if err != nil {
+		if errors.Is(err, cloudflare.ErrRecordExists) {
+			// Usually a CNAME with the same name. Retrying won't help until it is removed.
+			log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version}).Error("[createRecordIP] A conflicting record already exists")
+		} else {
+			log.WithFields(log.Fields{"err": err, "record": record.fqdn, "version": version}).Error("[createRecordIP] Error creating domain record")
+		}
+		runErrorScript(record, err, version, nil, IP)
+		return err
+	}
+
+	record.recordIDs[version] = recordID
+	ipChanges.add(1, record.fqdn, version.getRecordType())
+	runUpdateScript(record, version, nil, IP)
+	setCachedIP(record, IP, version)
+	return nil
+}
+
+// Returns a hint about how to fix err, or "" if there isn't one.
+func cloudflareErrorHint(err error) string {
+	switch {
+	case errors.Is(err, cloudflare.ErrUnauthorized):
+		return "The API key is invalid or doesn't have the Zone:Read and DNS:Edit permissions for the zone"
+	case errors.Is(err, cloudflare.ErrZoneNotFound):
+		return "The zone doesn't exist or the API key doesn't have access to it. Check Domain and DomainZoneID"
+	case errors.Is(err, cloudflare.ErrRateLimited):
+		return "The Cloudflare API rate limit was reached. Consider a longer Interval"
+	}
+	return ""
 }
 
 func main() {
 	showVersion := flag.Bool("version", false, "Display version info and exits")
 	showConfig := flag.Bool("showConfig", false, "Displays the config file parsed and exits")
+	daemon := flag.Bool("daemon", false, "Keep running and check the IP address on the Interval set in the config file")
 	configPath := flag.String("config", "config.yaml", "Path to the configuration file")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the records that would be created, updated or deleted without changing anything")
+	flag.BoolVar(&adoptRecords, "adopt", false, "Add the ownership marker to existing records that don't have it, instead of skipping them")
+	flag.BoolVar(&forceAdopt, "force-adopt", false, "Like -adopt, but also take over the records that have the marker of another instance")
 	flag.Parse()
 
 	if *showVersion {
@@ -394,16 +643,70 @@ func main() {
 
 	log.WithField("BuildInfo", BuildInfo).Trace("[main] Starting")
 
-	if conf.APIKey == "" {
-		log.Fatal("No APIkey found in config.yaml")
+	for _, zone := range conf.Zones {
+		if zone.Domain == "" {
+			log.Fatal("No Domain found in config.yaml")
+		}
+
+		if zone.APIKey == "" {
+			log.Fatalf("No APIkey found in config.yaml for %s", zone.Domain)
+		}
+
+		for _, record := range zone.Records {
+			if record.Name == "" {
+				log.Warnf("No Subdomain Specified. Using root domain (%s)\n", zone.Domain)
+			}
+
+			if record.IPv6Suffix != "" {
+				suffix := net.ParseIP(record.IPv6Suffix)
+				if suffix == nil || suffix.To4() != nil {
+					log.Fatalf("Invalid IPv6Suffix %q for %s", record.IPv6Suffix, record.fqdn)
+				}
+			}
+
+			if length := record.ipv6PrefixLength(); length < 1 || length > 128 {
+				log.Fatalf("Invalid IPv6PrefixLength %d for %s. It has to be between 1 and 128", length, record.fqdn)
+			}
+
+			if !isValidAddressLostPolicy(record.OnAddressLost) {
+				log.Fatalf("Invalid OnAddressLost %q for %s. Use keep, delete-after or replace-with", record.OnAddressLost, record.fqdn)
+			}
+
+			for _, address := range record.ReplaceWith {
+				if net.ParseIP(address) == nil {
+					log.Fatalf("Invalid ReplaceWith address %q for %s", address, record.fqdn)
+				}
+			}
+
+			if record.onAddressLost() == addressLostReplace && len(record.ReplaceWith) == 0 {
+				log.Fatalf("OnAddressLost is replace-with but there is no ReplaceWith address for %s", record.fqdn)
+			}
+
+			if !isValidDuplicatesPolicy(record.DuplicateRecords) {
+				log.Fatalf("Invalid DuplicateRecords %q for %s. Use refuse, update-all or update-one", record.DuplicateRecords, record.fqdn)
+			}
+		}
+	}
+
+	if !isValidAddressLostPolicy(conf.OnAddressLost) {
+		log.Fatalf("Invalid OnAddressLost %q. Use keep, delete-after or replace-with", conf.OnAddressLost)
 	}
 
-	if conf.Domain == "" {
-		log.Fatal("No Domain found in config.yaml")
+	if !isValidOwnershipMode(conf.OwnershipMarker) {
+		log.Fatalf("Invalid OwnershipMarker %q. Use comment, tag, txt or none", conf.OwnershipMarker)
 	}
 
-	if conf.SubDomainToUpdate == "" {
-		log.Warnf("No Subdomain Specified. Using root domain (%s)\n", conf.Domain)
+	if ownershipMode() == ownershipComment && len(ownerMarker()) > maxCommentLength {
+		log.Fatalf("InstanceID %q is too long. The ownership marker has to fit in a record's comment (%d characters)", conf.InstanceID, maxCommentLength)
+	}
+	warnOwnershipDefault()
+
+	if !isValidDuplicatesPolicy(conf.DuplicateRecords) {
+		log.Fatalf("Invalid DuplicateRecords %q. Use refuse, update-all or update-one", conf.DuplicateRecords)
+	}
+
+	if dryRun && *daemon {
+		log.Fatal("--dry-run can't be used with --daemon")
 	}
 
 	if conf.DisableIPv4 && conf.DisableIPv6 {
@@ -412,15 +715,48 @@ func main() {
 
 	// fmt.Printf("%s[%s%s%s] Checking %s%s\n", color.Cyan, color.Reset, time.Now().Format(time.RFC3339), color.Cyan, color.Reset, Config.Name)
 	// log.Printf("Checking %s", Config._Name)
-	httpClient = &http.Client{}
+	if err := setupIPSources(); err != nil {
+		log.WithField("error", err).Fatal("[main] Invalid IP source in config file")
+	}
+
+	if err := setupIPValidation(); err != nil {
+		log.WithField("error", err).Fatal("[main] Invalid IP range in config file")
+	}
 
-	if !conf.DisableIPv4 {
-		updateIP(IPv4)
+	if err := setupNotifiers(); err != nil {
+		log.WithField("error", err).Fatal("[main] Invalid notifier in config file")
 	}
 
-	if !conf.DisableIPv6 {
-		updateIP(IPv6)
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+	runContext = ctx
+	go func() {
+		<-ctx.Done()
+		// A second signal stops the program right away, without waiting for the notifications
+		stopSignals()
+	}()
+
+	httpClient = cloudflare.NewHTTPClient(conf.ConnectTimeout, conf.APITimeout)
+	for _, zone := range conf.Zones {
+		zone.client = getCloudflareClient(zone.APIKey)
 	}
 
+	if *daemon {
+		runDaemon(ctx)
+		waitForNotifications(notificationsTimeout)
+		httpClient.CloseIdleConnections()
+		return
+	}
+
+	result := runOnce()
+	waitForNotifications(notificationsTimeout)
 	httpClient.CloseIdleConnections()
+
+	if runAborted() {
+		log.WithFields(log.Fields{"succeeded": result.succeeded, "failed": result.failed}).Warn("[main] Stopped before every record was checked")
+		os.Exit(exitStopped)
+	}
+
+	log.WithFields(log.Fields{"succeeded": result.succeeded, "failed": result.failed}).Debug("[main] Done")
+	os.Exit(result.exitCode())
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mtzfederico/ddns-cf/cloudflare"
+)
+
+func TestRunResultExitCode(t *testing.T) {
+	var result runResult
+	if result.exitCode() != exitOK {
+		t.Errorf("Expected exitOK for an empty run, got %d", result.exitCode())
+	}
+
+	result.add(nil)
+	if result.exitCode() != exitOK {
+		t.Errorf("Expected exitOK, got %d", result.exitCode())
+	}
+
+	result.add(errors.New("failed"))
+	if result.exitCode() != exitPartialFailure {
+		t.Errorf("Expected exitPartialFailure, got %d", result.exitCode())
+	}
+
+	failed := runResult{}
+	failed.add(errors.New("failed"))
+	if failed.exitCode() != exitFailure {
+		t.Errorf("Expected exitFailure, got %d", failed.exitCode())
+	}
+}
+
+// An update of a record that was deleted in the meantime should create it again
+func TestUpdateRecordIPRecreatesDeletedRecord(t *testing.T) {
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	record := newMockRecord(t, "deleted", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "A", "name": "deleted.example.com", "content": "192.0.2.1"}]}`))
+		case "PATCH":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success": false, "errors": [{"code": 81044, "message": "Record does not exist."}]}`))
+		case "POST":
+			w.Write([]byte(`{"success": true, "result": {"id": "rec2", "type": "A", "name": "deleted.example.com", "content": "192.0.2.2"}}`))
+		}
+	})
+
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if record.recordIDs[IPv4] != "rec2" {
+		t.Errorf("Expected the new record ID rec2, got %q", record.recordIDs[IPv4])
+	}
+}
+
+func TestCloudflareErrorHint(t *testing.T) {
+	unauthorized := &cloudflare.APIError{StatusCode: http.StatusForbidden}
+	if cloudflareErrorHint(unauthorized) == "" {
+		t.Error("Expected a hint for an unauthorized error")
+	}
+
+	if hint := cloudflareErrorHint(errors.New("connection refused")); hint != "" {
+		t.Errorf("Expected no hint, got %q", hint)
+	}
+}
+
+// Creates a record in a zone whose client sends its requests to handler
+func newMockRecord(t *testing.T, name string, handler http.HandlerFunc) *Record {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := cloudflare.NewClient("test-token")
+	client.BaseURL = server.URL
+	zone := &Zone{Domain: "example.com", DomainZoneID: "zone123", client: client}
+	return &Record{Name: name, fqdn: getFQDN(name, zone.Domain), zone: zone, recordIDs: make(map[IPVersion]string)}
+}
+
+func TestUpdateRecordIPDuplicates(t *testing.T) {
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	var mu sync.Mutex
+	var requests []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		if r.Method == "GET" {
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "A", "content": "192.0.2.1"}, {"id": "rec2", "type": "A", "content": "192.0.2.9"}]}`))
+			return
+		}
+		w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+	}
+
+	tests := []struct {
+		policy   string
+		wantErr  error
+		requests []string
+	}{
+		{"", duplicateRecordsErr, []string{"GET /zones/zone123/dns_records"}},
+		{duplicatesUpdateAll, nil, []string{"GET /zones/zone123/dns_records", "PATCH /zones/zone123/dns_records/rec1", "PATCH /zones/zone123/dns_records/rec2"}},
+		{duplicatesUpdateOne, nil, []string{"GET /zones/zone123/dns_records", "DELETE /zones/zone123/dns_records/rec2", "PATCH /zones/zone123/dns_records/rec1"}},
+	}
+
+	for _, test := range tests {
+		requests = nil
+		record := newMockRecord(t, "dup-"+test.policy, handler)
+		record.DuplicateRecords = test.policy
+
+		err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2"))
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("Policy %q: expected error %v, got %v", test.policy, test.wantErr, err)
+		}
+		if strings.Join(requests, ", ") != strings.Join(test.requests, ", ") {
+			t.Errorf("Policy %q: expected requests %v, got %v", test.policy, test.requests, requests)
+		}
+	}
+
+	// update-one keeps the record that already has the address, so nothing but the duplicate changes
+	requests = nil
+	record := newMockRecord(t, "dup-keep-current", handler)
+	record.DuplicateRecords = duplicatesUpdateOne
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.9")); err != nil {
+		t.Fatal(err)
+	}
+	expected := "GET /zones/zone123/dns_records, DELETE /zones/zone123/dns_records/rec1"
+	if strings.Join(requests, ", ") != expected {
+		t.Errorf("Expected requests %s, got %v", expected, requests)
+	}
+}
+
+// update-one shouldn't delete anything when one of the records isn't managed by ddns-cf
+func TestUpdateRecordIPDuplicatesNotOwned(t *testing.T) {
+	conf = Config{DisableCFCache: true}
+	defer func() { conf = Config{} }()
+
+	var requests []string
+	record := newMockRecord(t, "dup-not-owned", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "GET" {
+			// The first record doesn't have the marker
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "A", "content": "192.0.2.1"}, {"id": "rec2", "type": "A", "content": "192.0.2.9", "comment": "` + ownerMarker() + `"}]}`))
+			return
+		}
+		w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+	})
+	record.DuplicateRecords = duplicatesUpdateOne
+
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2")); !errors.Is(err, notOwnedErr) {
+		t.Errorf("Expected notOwnedErr, got %v", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected only the records to be listed, got %v", requests)
+	}
+}
+
+func TestUpdateRecordIPPatch(t *testing.T) {
+	defer func() { conf = Config{} }()
+
+	var patches []map[string]any
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"success": true, "result": [{"id": "rec1", "type": "A", "content": "192.0.2.1", "ttl": 300, "proxied": false, "comment": "set by ops"}]}`))
+		case "PATCH":
+			var patch map[string]any
+			json.NewDecoder(r.Body).Decode(&patch)
+			patches = append(patches, patch)
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+		}
+	}
+
+	// Only the content is sent by default
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true, RecordTTL: 60}
+	record := newMockRecord(t, "patch", handler)
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || len(patches[0]) != 1 || patches[0]["content"] != "192.0.2.2" {
+		t.Errorf("Expected a patch with only the content, got %v", patches)
+	}
+
+	// The IP didn't change, but the enforced TTL and proxy status did
+	patches = nil
+	conf = Config{OwnershipMarker: ownershipNone, DisableCFCache: true, RecordTTL: 60, IsProxied: true, EnforceTTL: true, EnforceProxied: true}
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0]["ttl"] != float64(60) || patches[0]["proxied"] != true {
+		t.Errorf("Expected a patch with the TTL and proxy status, got %v", patches)
+	}
+}
+
+// A run stopped by SIGTERM or SIGINT shouldn't report every record as failed, or apply the OnAddressLost policy
+func TestUpdateIPStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runContext = ctx
+	defer func() {
+		runContext = context.Background()
+		conf = Config{}
+		notifiers = nil
+		delete(ipSources, IPv4)
+	}()
+
+	server, received := newNotifierServer(t)
+	record := newMockRecord(t, "stopped", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+	})
+	record.OnAddressLost = addressLostDelete
+	record.zone.Records = []*Record{record}
+	conf = Config{DisableCFCache: true, Zones: []*Zone{record.zone}, Notifiers: []NotifierConfig{{Type: "ntfy", URL: server.URL, Topic: "home"}}}
+	if err := setupNotifiers(); err != nil {
+		t.Fatal(err)
+	}
+	setMissingAddress(record, IPv4, missingAddress{Since: time.Now().Add(-2 * defaultAddressLostAfter)})
+	defer clearMissingAddress(record, IPv4)
+
+	ipSources[IPv4] = []configuredIPSource{{IPSource: staticSource{err: context.Canceled}, timeout: time.Second}}
+	result := updateIP(IPv4)
+	waitForNotifications(5 * time.Second)
+
+	if result.failed != 0 || result.succeeded != 0 {
+		t.Errorf("Expected nothing to be counted, got %+v", result)
+	}
+	if received.body != "" {
+		t.Errorf("Expected no notifications, got %q", received.body)
+	}
+}
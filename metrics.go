@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A small registry of metrics in the Prometheus text format, served on /metrics in daemon mode.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+const (
+	counterMetric   string = "counter"
+	gaugeMetric     string = "gauge"
+	histogramMetric string = "histogram"
+)
+
+// A metric with its values for every combination of labels.
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// The upper bounds of the histogram buckets
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// The value of a metric for one combination of labels.
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	// Histograms only. The count of observations in each bucket, not cumulative.
+	bucketCounts []uint64
+	count        uint64
+}
+
+func newMetric(name string, kind string, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *metricFamily {
+	family := newMetric(name, histogramMetric, help, labels...)
+	family.buckets = buckets
+	return family
+}
+
+// Returns the series for the label values, creating it if needed. The caller has to hold f.mu.
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := f.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+		if f.kind == histogramMetric {
+			series.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = series
+	}
+	return series
+}
+
+func (f *metricFamily) add(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value += value
+}
+
+func (f *metricFamily) set(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value = value
+}
+
+func (f *metricFamily) observe(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	series := f.get(labelValues)
+	series.value += value
+	series.count++
+	for i, bound := range f.buckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+			break
+		}
+	}
+}
+
+// Removes the series whose first label values are prefix.
+func (f *metricFamily) deleteMatching(prefix ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, series := range f.series {
+		if len(series.labelValues) >= len(prefix) && slicesEqual(series.labelValues[:len(prefix)], prefix) {
+			delete(f.series, key)
+		}
+	}
+}
+
+func slicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes the metric in the Prometheus text format.
+func (f *metricFamily) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := f.series[key]
+		labels := formatLabels(f.labels, series.labelValues)
+
+		if f.kind != histogramMetric {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(series.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += series.bucketCounts[i]
+			bucketLabels := formatLabels(append(f.labels, "le"), append(series.labelValues, formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, bucketLabels, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(append(f.labels, "le"), append(series.labelValues, "+Inf")), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatFloat(series.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, series.count)
+	}
+}
+
+// Returns the labels in the form {name="value",...}, or "" if there are none.
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	recordLastSuccess = newMetric("ddns_cf_record_last_success_timestamp_seconds", gaugeMetric, "The last time the record was checked and is up to date, as a Unix timestamp.", "record", "type")
+	publishedIP       = newMetric("ddns_cf_published_ip_info", gaugeMetric, "The address the record points to.", "record", "type", "ip")
+	ipChanges         = newMetric("ddns_cf_ip_changes_total", counterMetric, "The amount of times the record was created or changed to a new address.", "record", "type")
+	apiRequests       = newMetric("ddns_cf_cloudflare_requests_total", counterMetric, "The amount of requests sent to the Cloudflare API, including retries. A status of 0 means that there was no response.", "method", "endpoint", "status")
+	apiDuration       = newHistogram("ddns_cf_cloudflare_request_duration_seconds", "How long the requests to the Cloudflare API took.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "method", "endpoint")
+	ipSourceFailures  = newMetric("ddns_cf_ip_source_failures_total", counterMetric, "The amount of times an IP source failed to return an address.", "source", "version")
+	cacheHits         = newMetric("ddns_cf_cache_hits_total", counterMetric, "The amount of checks where the cached address was up to date, so Cloudflare wasn't asked.")
+	cacheMisses       = newMetric("ddns_cf_cache_misses_total", counterMetric, "The amount of checks where the cached address was missing, expired or outdated.")
+
+	metricFamilies = []*metricFamily{recordLastSuccess, publishedIP, ipChanges, apiRequests, apiDuration, ipSourceFailures, cacheHits, cacheMisses}
+)
+
+// Records that the record is up to date and points to address.
+func recordSucceeded(record *Record, version IPVersion, address net.IP) {
+	recordType := version.getRecordType()
+	recordLastSuccess.set(float64(time.Now().Unix()), record.fqdn, recordType)
+
+	publishedIP.deleteMatching(record.fqdn, recordType)
+	publishedIP.set(1, record.fqdn, recordType, ipToString(address))
+}
+
+// Records that the record was deleted.
+func recordDeleted(record *Record, version IPVersion) {
+	publishedIP.deleteMatching(record.fqdn, version.getRecordType())
+}
+
+// Passed to the Cloudflare clients as OnRequest.
+func observeAPIRequest(method string, endpoint string, status int, duration time.Duration) {
+	apiRequests.add(1, method, endpoint, strconv.Itoa(status))
+	apiDuration.observe(duration.Seconds(), method, endpoint)
+}
+
+// Serves the metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, family := range metricFamilies {
+		family.write(w)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricFamilyWrite(t *testing.T) {
+	counter := newMetric("test_requests_total", counterMetric, "Requests.", "method", "status")
+	counter.add(1, "GET", "200")
+	counter.add(2, "GET", "200")
+	counter.add(1, "POST", "0")
+
+	histogram := newHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "method")
+	histogram.observe(0.05, "GET")
+	histogram.observe(0.5, "GET")
+	histogram.observe(5, "GET")
+
+	var out strings.Builder
+	counter.write(&out)
+	histogram.write(&out)
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",status="200"} 3
+test_requests_total{method="POST",status="0"} 1
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="GET",le="0.1"} 1
+test_duration_seconds_bucket{method="GET",le="1"} 2
+test_duration_seconds_bucket{method="GET",le="+Inf"} 3
+test_duration_seconds_sum{method="GET"} 5.55
+test_duration_seconds_count{method="GET"} 3
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nExpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	record := &Record{fqdn: "metrics.example.com"}
+	recordSucceeded(record, IPv4, net.ParseIP("198.51.100.1"))
+	recordSucceeded(record, IPv4, net.ParseIP("198.51.100.2"))
+	observeAPIRequest("PATCH", "zones/:id/dns_records/:id", 200, 150*time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	// Only the current address is published
+	if !strings.Contains(body, `ddns_cf_published_ip_info{record="metrics.example.com",type="A",ip="198.51.100.2"} 1`) {
+		t.Errorf("Expected the current address in the metrics:\n%s", body)
+	}
+	if strings.Contains(body, `ip="198.51.100.1"`) {
+		t.Errorf("Expected the old address to be removed from the metrics:\n%s", body)
+	}
+	if !strings.Contains(body, `ddns_cf_cloudflare_requests_total{method="PATCH",endpoint="zones/:id/dns_records/:id",status="200"}`) {
+		t.Errorf("Expected the API request in the metrics:\n%s", body)
+	}
+	if !strings.Contains(body, `ddns_cf_record_last_success_timestamp_seconds{record="metrics.example.com",type="A"}`) {
+		t.Errorf("Expected the last success in the metrics:\n%s", body)
+	}
+}
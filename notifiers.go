@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notifiers for chat and push services. Each one formats the notification as a message with a title.
+
+const (
+	defaultTelegramURL string = "https://api.telegram.org"
+	defaultNtfyURL     string = "https://ntfy.sh"
+	defaultPushoverURL string = "https://api.pushover.net/1/messages.json"
+)
+
+// Returns the title and the text of the message sent for the notification.
+func notificationMessage(notification Notification) (string, string) {
+	var title, text string
+	switch notification.Event {
+	case eventChange:
+		title = notification.Record + " changed"
+		text = fmt.Sprintf("The %s record of %s changed from %s to %s on %s.", notification.Type, notification.Record, notification.OldIP, notification.NewIP, notification.Hostname)
+	case eventCreate:
+		title = notification.Record + " created"
+		text = fmt.Sprintf("The %s record of %s was created with %s on %s.", notification.Type, notification.Record, notification.NewIP, notification.Hostname)
+	case eventDetectionFailure:
+		title = "Failed to get the IP" + string(notification.Version) + " address"
+		text = fmt.Sprintf("%s couldn't find its public IP%s address: %s", notification.Hostname, notification.Version, notification.Error)
+	case eventSourceDisagreement:
+		title = "IP" + string(notification.Version) + " sources disagree"
+		text = fmt.Sprintf("%s is using %s, but %s", notification.Hostname, notification.NewIP, notification.Error)
+	default:
+		title = "Failed to update " + notification.Record
+		text = fmt.Sprintf("Error updating the %s record of %s on %s: %s", notification.Type, notification.Record, notification.Hostname, notification.Error)
+	}
+
+	if notification.Suppressed > 0 {
+		text += fmt.Sprintf(" %d earlier notifications were skipped because of the rate limit.", notification.Suppressed)
+	}
+	return title, text
+}
+
+func isErrorEvent(event string) bool {
+	return event == eventError || event == eventDetectionFailure || event == eventSourceDisagreement
+}
+
+// Creates a request that sends payload as JSON.
+func newJSONRequest(ctx context.Context, url string, payload any) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return newNotifierRequest(ctx, url, "application/json", bytes.NewReader(body))
+}
+
+func newNotifierRequest(ctx context.Context, url string, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+// Returns the URL from the config file without a trailing slash, or defaultURL if it is empty.
+func serviceURL(notifierConf NotifierConfig, defaultURL string) (string, error) {
+	serviceURL := notifierConf.URL
+	if serviceURL == "" {
+		serviceURL = defaultURL
+	}
+	if serviceURL == "" {
+		return "", errors.New("URL missing")
+	}
+	if _, err := url.ParseRequestURI(serviceURL); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(serviceURL, "/"), nil
+}
+
+// Posts messages to a Slack incoming webhook.
+// https://api.slack.com/messaging/webhooks
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newSlackNotifier(notifierConf NotifierConfig) (*slackNotifier, error) {
+	webhookURL, err := serviceURL(notifierConf, "")
+	if err != nil {
+		return nil, err
+	}
+	return &slackNotifier{url: webhookURL, client: &http.Client{}}, nil
+}
+
+func (s *slackNotifier) Name() string {
+	return "slack"
+}
+
+// Slack's text has to have &, < and > escaped.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (s *slackNotifier) Notify(ctx context.Context, notification Notification) error {
+	title, text := notificationMessage(notification)
+	payload := map[string]string{"text": "*" + slackEscaper.Replace(title) + "*\n" + slackEscaper.Replace(text)}
+
+	req, err := newJSONRequest(ctx, s.url, payload)
+	if err != nil {
+		return err
+	}
+	return sendNotifierRequest(s.client, req)
+}
+
+// Posts embeds to a Discord webhook.
+// https://discord.com/developers/docs/resources/webhook#execute-webhook
+type discordNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newDiscordNotifier(notifierConf NotifierConfig) (*discordNotifier, error) {
+	webhookURL, err := serviceURL(notifierConf, "")
+	if err != nil {
+		return nil, err
+	}
+	return &discordNotifier{url: webhookURL, client: &http.Client{}}, nil
+}
+
+func (d *discordNotifier) Name() string {
+	return "discord"
+}
+
+// The color of the embed's border for each event.
+var discordColors = map[string]int{
+	eventChange:             0x3498db,
+	eventCreate:             0x2ecc71,
+	eventError:              0xe74c3c,
+	eventDetectionFailure:   0xe67e22,
+	eventSourceDisagreement: 0xe67e22,
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Color       int    `json:"color"`
+	Timestamp   string `json:"timestamp"`
+}
+
+func (d *discordNotifier) Notify(ctx context.Context, notification Notification) error {
+	title, text := notificationMessage(notification)
+	embed := discordEmbed{Title: title, Description: text, Color: discordColors[notification.Event], Timestamp: notification.Time.Format(time.RFC3339)}
+	payload := map[string]any{"username": "ddns-cf", "embeds": []discordEmbed{embed}}
+
+	req, err := newJSONRequest(ctx, d.url, payload)
+	if err != nil {
+		return err
+	}
+	return sendNotifierRequest(d.client, req)
+}
+
+// Sends messages with a Telegram bot.
+// https://core.telegram.org/bots/api#sendmessage
+type telegramNotifier struct {
+	url    string
+	chatID string
+	client *http.Client
+}
+
+func newTelegramNotifier(notifierConf NotifierConfig) (*telegramNotifier, error) {
+	apiURL, err := serviceURL(notifierConf, defaultTelegramURL)
+	if err != nil {
+		return nil, err
+	}
+	if notifierConf.Token == "" {
+		return nil, errors.New("Token missing")
+	}
+	if notifierConf.ChatID == "" {
+		return nil, errors.New("ChatID missing")
+	}
+	return &telegramNotifier{url: apiURL + "/bot" + notifierConf.Token + "/sendMessage", chatID: notifierConf.ChatID, client: &http.Client{}}, nil
+}
+
+func (t *telegramNotifier) Name() string {
+	return "telegram"
+}
+
+func (t *telegramNotifier) Notify(ctx context.Context, notification Notification) error {
+	title, text := notificationMessage(notification)
+	// Sent as plain text so that the addresses and errors don't have to be escaped
+	payload := map[string]string{"chat_id": t.chatID, "text": title + "\n" + text}
+
+	req, err := newJSONRequest(ctx, t.url, payload)
+	if err != nil {
+		return err
+	}
+	return sendNotifierRequest(t.client, req)
+}
+
+// Publishes messages to an ntfy topic.
+// https://docs.ntfy.sh/publish/
+type ntfyNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newNtfyNotifier(notifierConf NotifierConfig) (*ntfyNotifier, error) {
+	serverURL, err := serviceURL(notifierConf, defaultNtfyURL)
+	if err != nil {
+		return nil, err
+	}
+	if notifierConf.Topic == "" {
+		return nil, errors.New("Topic missing")
+	}
+	return &ntfyNotifier{url: serverURL + "/" + url.PathEscape(notifierConf.Topic), token: notifierConf.Token, client: &http.Client{}}, nil
+}
+
+func (n *ntfyNotifier) Name() string {
+	return "ntfy"
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, notification Notification) error {
+	title, text := notificationMessage(notification)
+
+	req, err := newNotifierRequest(ctx, n.url, "text/plain; charset=utf-8", strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Title", title)
+	if isErrorEvent(notification.Event) {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	} else {
+		req.Header.Set("Tags", "globe_with_meridians")
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return sendNotifierRequest(n.client, req)
+}
+
+// Sends messages to a Gotify server.
+// https://gotify.net/docs/pushmsg
+type gotifyNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newGotifyNotifier(notifierConf NotifierConfig) (*gotifyNotifier, error) {
+	serverURL, err := serviceURL(notifierConf, "")
+	if err != nil {
+		return nil, err
+	}
+	if notifierConf.Token == "" {
+		return nil, errors.New("Token missing")
+	}
+	return &gotifyNotifier{url: serverURL + "/message", token: notifierConf.Token, client: &http.Client{}}, nil
+}
+
+func (g *gotifyNotifier) Name() string {
+	return "gotify"
+}
+
+func (g *gotifyNotifier) Notify(ctx context.Context, notification Notification) error {
+	title, text := notificationMessage(notification)
+
+	// Gotify's clients only show a popup for priorities of 4 and above, and play a sound from 8
+	priority := 5
+	if isErrorEvent(notification.Event) {
+		priority = 8
+	}
+	payload := map[string]any{"title": title, "message": text, "priority": priority}
+
+	req, err := newJSONRequest(ctx, g.url, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gotify-Key", g.token)
+	return sendNotifierRequest(g.client, req)
+}
+
+// Sends push notifications with Pushover.
+// https://pushover.net/api
+type pushoverNotifier struct {
+	url    string
+	token  string
+	user   string
+	client *http.Client
+}
+
+func newPushoverNotifier(notifierConf NotifierConfig) (*pushoverNotifier, error) {
+	apiURL, err := serviceURL(notifierConf, defaultPushoverURL)
+	if err != nil {
+		return nil, err
+	}
+	if notifierConf.Token == "" {
+		return nil, errors.New("Token missing")
+	}
+	if notifierConf.User == "" {
+		return nil, errors.New("User missing")
+	}
+	return &pushoverNotifier{url: apiURL, token: notifierConf.Token, user: notifierConf.User, client: &http.Client{}}, nil
+}
+
+func (p *pushoverNotifier) Name() string {
+	return "pushover"
+}
+
+func (p *pushoverNotifier) Notify(ctx context.Context, notification Notification) error {
+	title, text := notificationMessage(notification)
+
+	form := url.Values{"token": {p.token}, "user": {p.user}, "title": {title}, "message": {text}}
+	if isErrorEvent(notification.Event) {
+		// High priority, bypasses the user's quiet hours
+		form.Set("priority", "1")
+	}
+
+	req, err := newNotifierRequest(ctx, p.url, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	return sendNotifierRequest(p.client, req)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A request received by the stand-in service.
+type receivedRequest struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// Starts a stand-in for a notification service that saves the last request it receives.
+func newNotifierServer(t *testing.T) (*httptest.Server, *receivedRequest) {
+	received := &receivedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*received = receivedRequest{path: r.URL.Path, header: r.Header, body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestNotifierBackends(t *testing.T) {
+	change := Notification{Event: eventChange, Record: "home.example.com", Type: "A", Version: IPv4, OldIP: "198.51.100.1", NewIP: "198.51.100.2", Hostname: "router", Time: time.Now()}
+	failure := Notification{Event: eventError, Record: "home.example.com", Type: "AAAA", Version: IPv6, Error: "API call failed <403>", Hostname: "router", Time: time.Now()}
+
+	tests := []struct {
+		notifierConf NotifierConfig
+		notification Notification
+		check        func(t *testing.T, received *receivedRequest)
+	}{
+		{
+			notifierConf: NotifierConfig{Type: "slack"},
+			notification: failure,
+			check: func(t *testing.T, received *receivedRequest) {
+				var payload map[string]string
+				json.Unmarshal([]byte(received.body), &payload)
+				if !strings.HasPrefix(payload["text"], "*Failed to update home.example.com*\n") || !strings.Contains(payload["text"], "API call failed &lt;403&gt;") {
+					t.Errorf("Unexpected text: %q", payload["text"])
+				}
+			},
+		},
+		{
+			notifierConf: NotifierConfig{Type: "discord"},
+			notification: change,
+			check: func(t *testing.T, received *receivedRequest) {
+				var payload struct {
+					Embeds []discordEmbed `json:"embeds"`
+				}
+				json.Unmarshal([]byte(received.body), &payload)
+				if len(payload.Embeds) != 1 || payload.Embeds[0].Title != "home.example.com changed" || payload.Embeds[0].Color != discordColors[eventChange] {
+					t.Errorf("Unexpected embeds: %+v", payload.Embeds)
+				}
+				if !strings.Contains(payload.Embeds[0].Description, "from 198.51.100.1 to 198.51.100.2") {
+					t.Errorf("Unexpected description: %q", payload.Embeds[0].Description)
+				}
+			},
+		},
+		{
+			notifierConf: NotifierConfig{Type: "telegram", Token: "123:abc", ChatID: "-100200"},
+			notification: change,
+			check: func(t *testing.T, received *receivedRequest) {
+				if received.path != "/bot123:abc/sendMessage" {
+					t.Errorf("Unexpected path: %s", received.path)
+				}
+				var payload map[string]string
+				json.Unmarshal([]byte(received.body), &payload)
+				if payload["chat_id"] != "-100200" || !strings.HasPrefix(payload["text"], "home.example.com changed\n") {
+					t.Errorf("Unexpected payload: %v", payload)
+				}
+			},
+		},
+		{
+			notifierConf: NotifierConfig{Type: "ntfy", Topic: "home-dns", Token: "tk_secret"},
+			notification: failure,
+			check: func(t *testing.T, received *receivedRequest) {
+				if received.path != "/home-dns" {
+					t.Errorf("Unexpected path: %s", received.path)
+				}
+				if received.header.Get("Title") != "Failed to update home.example.com" || received.header.Get("Priority") != "high" || received.header.Get("Authorization") != "Bearer tk_secret" {
+					t.Errorf("Unexpected headers: %v", received.header)
+				}
+				if !strings.Contains(received.body, "API call failed <403>") {
+					t.Errorf("Unexpected body: %q", received.body)
+				}
+			},
+		},
+		{
+			notifierConf: NotifierConfig{Type: "gotify", Token: "app-token"},
+			notification: failure,
+			check: func(t *testing.T, received *receivedRequest) {
+				if received.path != "/message" || received.header.Get("X-Gotify-Key") != "app-token" {
+					t.Errorf("Unexpected request: %s %v", received.path, received.header)
+				}
+				var payload struct {
+					Title    string `json:"title"`
+					Priority int    `json:"priority"`
+				}
+				json.Unmarshal([]byte(received.body), &payload)
+				if payload.Title != "Failed to update home.example.com" || payload.Priority != 8 {
+					t.Errorf("Unexpected payload: %+v", payload)
+				}
+			},
+		},
+		{
+			notifierConf: NotifierConfig{Type: "pushover", Token: "app-token", User: "user-key"},
+			notification: change,
+			check: func(t *testing.T, received *receivedRequest) {
+				form, err := url.ParseQuery(received.body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if form.Get("token") != "app-token" || form.Get("user") != "user-key" || form.Get("title") != "home.example.com changed" || form.Get("priority") != "" {
+					t.Errorf("Unexpected form: %v", form)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.notifierConf.Type, func(t *testing.T) {
+			server, received := newNotifierServer(t)
+			test.notifierConf.URL = server.URL
+
+			notifier, err := newNotifier(test.notifierConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := notifier.Notify(context.Background(), test.notification); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, received)
+		})
+	}
+}
+
+func TestNotifierBackendsMissingOptions(t *testing.T) {
+	for _, notifierConf := range []NotifierConfig{
+		{Type: "slack"},
+		{Type: "discord"},
+		{Type: "telegram", ChatID: "1"},
+		{Type: "telegram", Token: "123:abc"},
+		{Type: "ntfy"},
+		{Type: "gotify", Token: "app-token"},
+		{Type: "gotify", URL: "https://gotify.example.com"},
+		{Type: "pushover", Token: "app-token"},
+	} {
+		if _, err := newNotifier(notifierConf); err == nil {
+			t.Errorf("Expected an error for %+v", notifierConf)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{limit: 2, period: time.Hour}
+	start := time.Now()
+
+	for i, expected := range []bool{true, true, false, false} {
+		if allowed, _ := limiter.allow(start.Add(time.Duration(i) * time.Minute)); allowed != expected {
+			t.Errorf("Notification %d: expected %t, got %t", i, expected, allowed)
+		}
+	}
+
+	// The first notification is out of the period
+	allowed, suppressed := limiter.allow(start.Add(time.Hour))
+	if !allowed || suppressed != 2 {
+		t.Errorf("Expected the notification to be sent with 2 suppressed, got %t and %d", allowed, suppressed)
+	}
+	if allowed, _ := limiter.allow(start.Add(time.Hour)); allowed {
+		t.Error("Expected the notification to be skipped")
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		notifiers = nil
+	}()
+
+	server, received := newNotifierServer(t)
+	conf = Config{Notifiers: []NotifierConfig{{Type: "ntfy", URL: server.URL, Topic: "home", RateLimit: 1}}}
+	if err := setupNotifiers(); err != nil {
+		t.Fatal(err)
+	}
+
+	record := &Record{fqdn: "home.example.com"}
+	for i := 0; i < 3; i++ {
+		notify(eventCreate, record, IPv4, nil, nil, nil)
+		waitForNotifications(5 * time.Second)
+	}
+	if received.body == "" {
+		t.Fatal("Expected the first notification to be sent")
+	}
+
+	// Let the next notification through to check that the skipped ones are counted
+	notifiers[0].limiter.sent = nil
+	notify(eventCreate, record, IPv4, nil, nil, nil)
+	waitForNotifications(5 * time.Second)
+	if !strings.Contains(received.body, "2 earlier notifications were skipped") {
+		t.Errorf("Expected the skipped notifications to be counted, got %q", received.body)
+	}
+}
+
+func TestNotifyRateLimitOnlyAffectsItsNotifier(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		notifiers = nil
+	}()
+
+	limitedServer, limited := newNotifierServer(t)
+	unlimitedServer, unlimited := newNotifierServer(t)
+	conf = Config{Notifiers: []NotifierConfig{
+		{Type: "ntfy", URL: limitedServer.URL, Topic: "home", RateLimit: 1},
+		{Type: "ntfy", URL: unlimitedServer.URL, Topic: "home"},
+	}}
+	if err := setupNotifiers(); err != nil {
+		t.Fatal(err)
+	}
+
+	record := &Record{fqdn: "home.example.com"}
+	for i := 0; i < 3; i++ {
+		notify(eventCreate, record, IPv4, nil, nil, nil)
+		waitForNotifications(5 * time.Second)
+	}
+
+	// Let the next notification through the rate limit
+	notifiers[0].limiter.sent = nil
+	notify(eventCreate, record, IPv4, nil, nil, nil)
+	waitForNotifications(5 * time.Second)
+
+	if !strings.Contains(limited.body, "2 earlier notifications were skipped") {
+		t.Errorf("Expected the skipped notifications to be counted, got %q", limited.body)
+	}
+	if strings.Contains(unlimited.body, "skipped") {
+		t.Errorf("Expected the notifier without a RateLimit to have nothing skipped, got %q", unlimited.body)
+	}
+}
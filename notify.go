@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The events that trigger notifications.
+const (
+	// The address of a record changed
+	eventChange string = "change"
+	// A record was created
+	eventCreate string = "create"
+	// A record couldn't be checked, created, updated or deleted. Sent with the error script.
+	eventError string = "error"
+	// The device's public address couldn't be found. Sent once per IP version, without a record.
+	eventDetectionFailure string = "detection-failure"
+	// The quorum was reached in consensus mode, but some of the IP sources returned a different address. Sent once per IP version, without a record.
+	eventSourceDisagreement string = "source-disagreement"
+)
+
+var notificationEvents = []string{eventChange, eventCreate, eventError, eventDetectionFailure, eventSourceDisagreement}
+
+// The data sent to the notifiers. The fields can be used in the body templates, e.g. {{.Record}}.
+type Notification struct {
+	Event string `json:"event"`
+	// The record's FQDN. Empty for detection-failure and source-disagreement.
+	Record string `json:"record,omitempty"`
+	// "A" or "AAAA"
+	Type    string    `json:"type"`
+	Version IPVersion `json:"version"`
+	OldIP   string    `json:"oldIP,omitempty"`
+	NewIP   string    `json:"newIP,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// The hostname of the device running ddns-cf
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+	// The amount of notifications that were not sent to this notifier because of its RateLimit since the last one.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// A service that notifications are sent to.
+type Notifier interface {
+	// A short name used in the logs
+	Name() string
+	// Sends the notification once. Retries are handled by the caller.
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// The options for a notifier in the config file.
+type NotifierConfig struct {
+	// The type of notifier: "webhook", "slack", "discord", "telegram", "ntfy", "gotify" or "pushover". Defaults to "webhook".
+	Type string `yaml:"Type"`
+	// The URL the request is sent to. For Slack and Discord it is the webhook's URL.
+	// For the other services it is the server's URL, which defaults to the public one (gotify has to have it set).
+	URL string `yaml:"URL"`
+	// The bot token for telegram, the application token for gotify and pushover, or the access token for ntfy.
+	Token string `yaml:"Token"`
+	// The chat that the telegram bot sends the messages to.
+	ChatID string `yaml:"ChatID"`
+	// The user or group key that pushover sends the notifications to.
+	User string `yaml:"User"`
+	// The ntfy topic to publish to.
+	Topic string `yaml:"Topic"`
+	// The HTTP method of a webhook's request. Defaults to POST.
+	Method string `yaml:"Method"`
+	// Extra headers sent with the request. e.g. {"Authorization": "Bearer <token>"}
+	Headers map[string]string `yaml:"Headers"`
+	// A Go text/template for a webhook's body. The fields of Notification can be used, and {{json .Error}} quotes a value for JSON. Defaults to the notification as JSON.
+	Body string `yaml:"Body"`
+	// The events to send: "change", "create", "error", "detection-failure" and "source-disagreement". If left empty, all of them are sent.
+	Events []string `yaml:"Events"`
+	// How many times to retry a notification that failed because of a connection error, a 5xx or a 429. Defaults to 3, -1 disables retries.
+	Retries int `yaml:"Retries"`
+	// The delay before the first retry. It doubles on every retry. Defaults to 1s.
+	RetryDelay time.Duration `yaml:"RetryDelay"`
+	// How long a single attempt can take. Defaults to 10s.
+	Timeout time.Duration `yaml:"Timeout"`
+	// The most notifications sent in RateLimitPeriod. The rest are skipped, and counted in the next one that is sent. If left empty, there is no limit.
+	RateLimit int `yaml:"RateLimit"`
+	// The period of the RateLimit. Defaults to 1h.
+	RateLimitPeriod time.Duration `yaml:"RateLimitPeriod"`
+}
+
+const (
+	defaultNotifierRetries    int           = 3
+	defaultNotifierRetryDelay time.Duration = time.Second
+	defaultNotifierTimeout    time.Duration = 10 * time.Second
+	defaultRateLimitPeriod    time.Duration = time.Hour
+	// How long to wait for the notifications that are still being sent before exiting
+	notificationsTimeout time.Duration = 30 * time.Second
+)
+
+var unknownNotifierErr = errors.New("unknown notifier type")
+
+// Returned by a notifier when the service answered with an error status.
+type notifierStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *notifierStatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// A notifier and the options that apply to every type of notifier.
+type configuredNotifier struct {
+	Notifier
+	// The events to send. Every event is sent if it is empty.
+	events     []string
+	retries    int
+	retryDelay time.Duration
+	timeout    time.Duration
+	// nil if there is no RateLimit
+	limiter *rateLimiter
+}
+
+// Limits the amount of notifications sent in a period, so that an address that keeps changing doesn't send hundreds of them.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	period time.Duration
+	// When the notifications in the current period were sent
+	sent []time.Time
+	// The amount of notifications skipped since the last one that was sent
+	suppressed int
+}
+
+// Reports whether a notification can be sent at now. If it can, it also returns how many were skipped before it.
+func (l *rateLimiter) allow(now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the notifications sent before the current period
+	start := 0
+	for start < len(l.sent) && now.Sub(l.sent[start]) >= l.period {
+		start++
+	}
+	l.sent = l.sent[start:]
+
+	if len(l.sent) >= l.limit {
+		l.suppressed++
+		return false, 0
+	}
+
+	l.sent = append(l.sent, now)
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+var (
+	// Set by setupNotifiers.
+	notifiers []configuredNotifier
+	// The notifications being sent. Waited for by waitForNotifications.
+	pendingNotifications sync.WaitGroup
+	// Set by setupNotifiers.
+	hostname string
+)
+
+// Creates the notifiers from the config file.
+func setupNotifiers() error {
+	hostname, _ = os.Hostname()
+
+	notifiers = make([]configuredNotifier, 0, len(conf.Notifiers))
+	for i, notifierConf := range conf.Notifiers {
+		notifier, err := newNotifier(notifierConf)
+		if err != nil {
+			return fmt.Errorf("notifier %d: %w", i, err)
+		}
+
+		for _, event := range notifierConf.Events {
+			if !slices.Contains(notificationEvents, event) {
+				return fmt.Errorf("notifier %d: unknown event %q. Use %s", i, event, strings.Join(notificationEvents, ", "))
+			}
+		}
+
+		configured := configuredNotifier{
+			Notifier:   notifier,
+			events:     notifierConf.Events,
+			retries:    notifierConf.Retries,
+			retryDelay: notifierConf.RetryDelay,
+			timeout:    notifierConf.Timeout,
+		}
+
+		switch {
+		case configured.retries < 0:
+			configured.retries = 0
+		case configured.retries == 0:
+			configured.retries = defaultNotifierRetries
+		}
+		if configured.retryDelay <= 0 {
+			configured.retryDelay = defaultNotifierRetryDelay
+		}
+		if configured.timeout <= 0 {
+			configured.timeout = defaultNotifierTimeout
+		}
+
+		if notifierConf.RateLimit > 0 {
+			configured.limiter = &rateLimiter{limit: notifierConf.RateLimit, period: notifierConf.RateLimitPeriod}
+			if configured.limiter.period <= 0 {
+				configured.limiter.period = defaultRateLimitPeriod
+			}
+		}
+		notifiers = append(notifiers, configured)
+	}
+	return nil
+}
+
+func newNotifier(notifierConf NotifierConfig) (Notifier, error) {
+	switch strings.ToLower(notifierConf.Type) {
+	case "webhook", "":
+		return newWebhookNotifier(notifierConf)
+	case "slack":
+		return newSlackNotifier(notifierConf)
+	case "discord":
+		return newDiscordNotifier(notifierConf)
+	case "telegram":
+		return newTelegramNotifier(notifierConf)
+	case "ntfy":
+		return newNtfyNotifier(notifierConf)
+	case "gotify":
+		return newGotifyNotifier(notifierConf)
+	case "pushover":
+		return newPushoverNotifier(notifierConf)
+	default:
+		return nil, fmt.Errorf("%w: %q", unknownNotifierErr, notifierConf.Type)
+	}
+}
+
+// Sends the event to every notifier that wants it, in the background. record is nil for detection-failure and source-disagreement.
+// Nothing is sent in dry-run mode.
+func notify(event string, record *Record, version IPVersion, oldIP, newIP net.IP, err error) {
+	if dryRun || len(notifiers) == 0 {
+		return
+	}
+
+	notification := Notification{
+		Event:    event,
+		Type:     version.getRecordType(),
+		Version:  version,
+		OldIP:    ipToString(oldIP),
+		NewIP:    ipToString(newIP),
+		Hostname: hostname,
+		Time:     time.Now(),
+	}
+	if record != nil {
+		notification.Record = record.fqdn
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+
+	for _, notifier := range notifiers {
+		if len(notifier.events) > 0 && !slices.Contains(notifier.events, event) {
+			continue
+		}
+
+		// Each notifier gets its own copy, since the amount of skipped notifications is different for each one
+		n := notification
+		if notifier.limiter != nil {
+			allowed, suppressed := notifier.limiter.allow(n.Time)
+			if !allowed {
+				log.WithFields(log.Fields{"notifier": notifier.Name(), "event": event, "record": n.Record, "version": version}).Info("[notify] Rate limit reached. Skipping the notification")
+				continue
+			}
+			n.Suppressed = suppressed
+		}
+
+		pendingNotifications.Add(1)
+		go func(notifier configuredNotifier, n Notification) {
+			defer pendingNotifications.Done()
+			notifier.send(n)
+		}(notifier, n)
+	}
+}
+
+// Sends the notification, retrying when it fails because of a connection error, a 5xx or a 429.
+func (n configuredNotifier) send(notification Notification) error {
+	fields := log.Fields{"notifier": n.Name(), "event": notification.Event, "record": notification.Record, "version": notification.Version}
+
+	delay := n.retryDelay
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+		err = n.Notify(ctx, notification)
+		cancel()
+
+		if err == nil {
+			log.WithFields(fields).Debug("[notify] Notification sent")
+			return nil
+		}
+
+		var statusErr *notifierStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode < 500 && statusErr.StatusCode != 429 {
+			break
+		}
+		log.WithFields(fields).WithFields(log.Fields{"error": err, "attempt": attempt + 1}).Debug("[notify] Notification failed")
+	}
+
+	log.WithFields(fields).WithField("error", err).Error("[notify] Failed to send the notification")
+	return err
+}
+
+// Waits up to timeout for the notifications that are being sent.
+func waitForNotifications(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pendingNotifications.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("[waitForNotifications] Some notifications were not sent in time")
+	}
+}
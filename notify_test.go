@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		notifiers = nil
+	}()
+
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("Expected PUT, got %s", r.Method)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Missing the Authorization header: %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	conf = Config{Notifiers: []NotifierConfig{{
+		URL:     server.URL,
+		Method:  "put",
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Body:    `{{.Event}} {{.Record}} {{.Type}} {{.OldIP}} -> {{.NewIP}} {{json .Error}}`,
+		Events:  []string{eventChange, eventError},
+	}}}
+	if err := setupNotifiers(); err != nil {
+		t.Fatal(err)
+	}
+
+	record := &Record{fqdn: "home.example.com"}
+	notify(eventChange, record, IPv4, net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.2"), nil)
+	// Filtered out by Events
+	notify(eventCreate, record, IPv4, nil, net.ParseIP("198.51.100.2"), nil)
+	waitForNotifications(5 * time.Second)
+	notify(eventError, record, IPv6, nil, nil, errors.New(`"quoted" error`))
+	waitForNotifications(5 * time.Second)
+
+	expected := []string{
+		`change home.example.com A 198.51.100.1 -> 198.51.100.2 ""`,
+		`error home.example.com AAAA  ->  "\"quoted\" error"`,
+	}
+	if len(bodies) != len(expected) {
+		t.Fatalf("Expected %d notifications, got %d: %q", len(expected), len(bodies), bodies)
+	}
+	for i := range expected {
+		if bodies[i] != expected[i] {
+			t.Errorf("Expected body %q, got %q", expected[i], bodies[i])
+		}
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	webhook, err := newWebhookNotifier(NotifierConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := configuredNotifier{Notifier: webhook, retries: 3, retryDelay: time.Millisecond, timeout: time.Second}
+
+	if err := notifier.send(Notification{Event: eventCreate}); err != nil {
+		t.Errorf("Expected the notification to be sent after retrying, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Client errors are not retried
+	attempts = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if err := notifier.send(Notification{Event: eventCreate}); err == nil {
+		t.Error("Expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestSetupNotifiersInvalid(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		notifiers = nil
+	}()
+
+	for _, notifierConf := range []NotifierConfig{
+		{},
+		{Type: "carrier-pigeon", URL: "https://example.com"},
+		{URL: "https://example.com", Events: []string{"changed"}},
+		{URL: "https://example.com", Body: "{{.Unknown}}"},
+		{URL: "https://example.com", Body: "{{.Record"},
+	} {
+		conf = Config{Notifiers: []NotifierConfig{notifierConf}}
+		if err := setupNotifiers(); err == nil {
+			t.Errorf("Expected an error for %+v", notifierConf)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"mtzfederico/ddns-cf/cloudflare"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The options for OwnershipMarker, which decides how ddns-cf marks the records it manages.
+const (
+	// "managed-by=ddns-cf/<InstanceID>" in the record's comment
+	ownershipComment string = "comment"
+	// A "managed-by:ddns-cf/<InstanceID>" tag on the record. Tags are only available on paid plans.
+	ownershipTag string = "tag"
+	// A TXT record named "_ddns-cf.<name>" with "managed-by=ddns-cf/<InstanceID>" as its content, like external-dns does.
+	ownershipTXT string = "txt"
+	// Every record is considered to be managed by ddns-cf
+	ownershipNone string = "none"
+)
+
+const defaultInstanceID string = "default"
+
+// The start of every instance's marker. Followed by the InstanceID.
+const ownerMarkerPrefix string = "managed-by=ddns-cf/"
+
+// The prefix of the name of the TXT record that marks a record as managed by ddns-cf.
+const ownerTXTPrefix string = "_ddns-cf."
+
+// The longest comment a record can have on the free plan. Paid plans allow 500 characters.
+// https://developers.cloudflare.com/dns/manage-dns-records/reference/record-attributes/
+const maxCommentLength int = 100
+
+var (
+	notOwnedErr = errors.New("the record is not managed by ddns-cf. Use -adopt to take it over")
+	// The record has the marker of another instance of ddns-cf
+	ownedByOtherErr = errors.New("the record is managed by another instance of ddns-cf. Use -force-adopt to take it over")
+	// The marker doesn't fit in the record's comment
+	commentTooLongErr = errors.New("the comment would be longer than Cloudflare allows with the ownership marker. Shorten it or use another OwnershipMarker")
+)
+
+// Set by the -adopt flag. Records without the ownership marker get it added instead of being skipped.
+var adoptRecords bool
+
+// Set by the -force-adopt flag. Records with the marker of another instance are adopted too, and the other marker is removed.
+var forceAdopt bool
+
+// Returns the OwnershipMarker option, defaulting to "comment".
+func ownershipMode() string {
+	if conf.OwnershipMarker == "" {
+		return ownershipComment
+	}
+	return conf.OwnershipMarker
+}
+
+// Reports whether mode is one of the OwnershipMarker options.
+func isValidOwnershipMode(mode string) bool {
+	switch mode {
+	case "", ownershipComment, ownershipTag, ownershipTXT, ownershipNone:
+		return true
+	}
+	return false
+}
+
+// Returns the text that marks a record as managed by this instance of ddns-cf.
+func ownerMarker() string {
+	instanceID := conf.InstanceID
+	if instanceID == "" {
+		instanceID = defaultInstanceID
+	}
+	return ownerMarkerPrefix + instanceID
+}
+
+// Returns the marker as a tag. Cloudflare tags are in the form "name:value".
+func ownerTag() string {
+	return strings.Replace(ownerMarker(), "=", ":", 1)
+}
+
+// Reports whether marker is the marker of any instance of ddns-cf.
+func isOwnerMarker(marker string) bool {
+	return strings.HasPrefix(marker, ownerMarkerPrefix)
+}
+
+// Reports whether tag is the tag of any instance of ddns-cf.
+func isOwnerTag(tag string) bool {
+	return strings.HasPrefix(tag, strings.Replace(ownerMarkerPrefix, "=", ":", 1))
+}
+
+// Returns the marker or tag of another instance of ddns-cf found on current, or "" if there isn't one.
+func otherOwnerMarker(current cloudflare.DNSRecord) string {
+	for _, word := range strings.Fields(current.Comment) {
+		if isOwnerMarker(word) && word != ownerMarker() {
+			return word
+		}
+	}
+	for _, tag := range current.Tags {
+		if isOwnerTag(tag) && tag != ownerTag() {
+			return tag
+		}
+	}
+	return ""
+}
+
+// Returns the name of the TXT record that marks the record as managed by ddns-cf.
+func getOwnerTXTName(record *Record) string {
+	return ownerTXTPrefix + record.fqdn
+}
+
+// Reports whether current has this instance's ownership marker.
+func isOwned(record *Record, current cloudflare.DNSRecord) (bool, error) {
+	switch ownershipMode() {
+	case ownershipNone:
+		return true, nil
+	case ownershipTag:
+		return slices.Contains(current.Tags, ownerTag()), nil
+	case ownershipTXT:
+		return hasOwnerTXT(record)
+	default:
+		// Compared word by word, so that the marker of instance "home" doesn't match "home-lab"
+		return slices.Contains(strings.Fields(current.Comment), ownerMarker()), nil
+	}
+}
+
+// Reports whether the record's companion TXT record has this instance's marker.
+func hasOwnerTXT(record *Record) (bool, error) {
+	params := cloudflare.ListDNSRecordsParams{Type: "TXT", Name: getOwnerTXTName(record)}
+	records, err := record.zone.client.ListDNSRecords(runContext, record.zone.DomainZoneID, params)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get the ownership TXT record. %w", err)
+	}
+
+	for _, txt := range records {
+		// The content of TXT records is usually quoted
+		if strings.Trim(txt.Content, `"`) == ownerMarker() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Adds the ownership marker to a record that is about to be created.
+func stampNewRecord(newRecord *cloudflare.DNSRecord) {
+	switch ownershipMode() {
+	case ownershipComment:
+		newRecord.Comment = ownerMarker()
+	case ownershipTag:
+		newRecord.Tags = []string{ownerTag()}
+	}
+}
+
+// Adds the ownership marker to an existing record, keeping its comment and tags.
+// The markers of other instances are removed, since only -force-adopt gets this far with them.
+// For comments and tags it is added to patch. For TXT markers the companion record is created.
+func stampExistingRecord(record *Record, current cloudflare.DNSRecord, patch *cloudflare.DNSRecordPatch) error {
+	switch ownershipMode() {
+	case ownershipComment:
+		words := slices.DeleteFunc(strings.Fields(current.Comment), isOwnerMarker)
+		comment := strings.Join(append(words, ownerMarker()), " ")
+		if len(comment) > maxCommentLength {
+			return fmt.Errorf("%w: %d characters for %s (%s)", commentTooLongErr, len(comment), record.fqdn, current.ID)
+		}
+		patch.Comment = comment
+	case ownershipTag:
+		patch.Tags = append(slices.DeleteFunc(slices.Clone(current.Tags), isOwnerTag), ownerTag())
+	case ownershipTXT:
+		return createOwnerTXT(record)
+	}
+	return nil
+}
+
+// Creates the record's companion TXT record, unless it already exists.
+func createOwnerTXT(record *Record) error {
+	if ownershipMode() != ownershipTXT {
+		return nil
+	}
+
+	owned, err := hasOwnerTXT(record)
+	if err != nil || owned {
+		return err
+	}
+
+	txt := cloudflare.DNSRecord{Type: "TXT", Name: getOwnerTXTName(record), Content: `"` + ownerMarker() + `"`, TTL: 1}
+	if dryRun {
+		printPlan(planCreate, txt.Type, txt.Name, txt.Content)
+		return nil
+	}
+
+	if _, err := record.zone.client.CreateDNSRecord(runContext, record.zone.DomainZoneID, txt); err != nil {
+		return fmt.Errorf("Failed to create the ownership TXT record. %w", err)
+	}
+
+	log.WithFields(log.Fields{"record": record.fqdn, "name": txt.Name}).Info("[createOwnerTXT] Ownership TXT record created")
+	return nil
+}
+
+// Warns that records created before the ownership markers were added will be skipped until they are adopted.
+// Only shown when OwnershipMarker isn't set, so it goes away once the marker is chosen explicitly.
+func warnOwnershipDefault() {
+	if conf.OwnershipMarker != "" || adoptRecords || forceAdopt {
+		return
+	}
+	log.WithField("marker", ownerMarker()).Warn("[main] OwnershipMarker isn't set, so only records with the ownership comment are changed. " +
+		"Records created by older versions of ddns-cf don't have it: run once with -adopt to add it, or set OwnershipMarker to \"none\" to keep the old behavior. " +
+		"Set OwnershipMarker to \"comment\" to hide this warning")
+}
+
+// Checks that ddns-cf manages current before it is changed or deleted.
+// Returns true if the marker has to be added to it (-adopt), or notOwnedErr if it can't be touched.
+func checkOwnership(record *Record, version IPVersion, current cloudflare.DNSRecord) (bool, error) {
+	owned, err := isOwned(record, current)
+	if err != nil {
+		return false, err
+	}
+	if owned {
+		return false, nil
+	}
+
+	fields := log.Fields{"record": record.fqdn, "version": version, "recordID": current.ID, "marker": ownerMarker(), "ownershipMarker": ownershipMode()}
+	if !adoptRecords && !forceAdopt {
+		log.WithFields(fields).Warn("[checkOwnership] The record doesn't have the ownership marker. Skipping it")
+		return false, fmt.Errorf("%w: %s (%s)", notOwnedErr, record.fqdn, current.ID)
+	}
+
+	// Otherwise both instances would claim the record
+	if other := otherOwnerMarker(current); other != "" && !forceAdopt {
+		log.WithFields(fields).WithField("otherMarker", other).Warn("[checkOwnership] The record has the marker of another instance. Skipping it")
+		return false, fmt.Errorf("%w: %s (%s) has %s", ownedByOtherErr, record.fqdn, current.ID, other)
+	}
+
+	log.WithFields(fields).Info("[checkOwnership] Adopting the record")
+	return true, nil
+}
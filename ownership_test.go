@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"mtzfederico/ddns-cf/cloudflare"
+)
+
+func TestUpdateRecordIPOwnership(t *testing.T) {
+	conf = Config{DisableCFCache: true, InstanceID: "home"}
+	defer func() {
+		conf = Config{}
+		adoptRecords = false
+		forceAdopt = false
+	}()
+
+	var patches []map[string]any
+	comment := "set by ops"
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "result": []map[string]any{{"id": "rec1", "type": "A", "content": "192.0.2.1", "comment": comment}}})
+		case "PATCH":
+			var patch map[string]any
+			json.NewDecoder(r.Body).Decode(&patch)
+			patches = append(patches, patch)
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+		}
+	}
+
+	// Records without the marker are skipped
+	record := newMockRecord(t, "owned", handler)
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2")); !errors.Is(err, notOwnedErr) {
+		t.Errorf("Expected notOwnedErr, got %v", err)
+	}
+	if len(patches) != 0 {
+		t.Errorf("Expected no updates, got %v", patches)
+	}
+
+	// -adopt adds the marker, keeping the comment
+	adoptRecords = true
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0]["comment"] != "set by ops managed-by=ddns-cf/home" {
+		t.Errorf("Expected the marker to be added to the comment, got %v", patches)
+	}
+
+	// Records with the marker are updated
+	adoptRecords = false
+	patches = nil
+	comment = "set by ops managed-by=ddns-cf/home"
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.3")); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0]["comment"] != nil {
+		t.Errorf("Expected only the content to be updated, got %v", patches)
+	}
+
+	// The marker can't be added when the comment would be too long
+	adoptRecords = true
+	patches = nil
+	comment = strings.Repeat("x", maxCommentLength-10)
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.4")); !errors.Is(err, commentTooLongErr) {
+		t.Errorf("Expected commentTooLongErr, got %v", err)
+	}
+	if len(patches) != 0 {
+		t.Errorf("Expected no updates, got %v", patches)
+	}
+
+	// Records of another instance aren't adopted
+	patches = nil
+	comment = "set by ops managed-by=ddns-cf/office"
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.4")); !errors.Is(err, ownedByOtherErr) {
+		t.Errorf("Expected ownedByOtherErr, got %v", err)
+	}
+	if len(patches) != 0 {
+		t.Errorf("Expected no updates, got %v", patches)
+	}
+
+	// Unless it is forced, which replaces the other instance's marker
+	adoptRecords = false
+	forceAdopt = true
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.4")); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0]["comment"] != "set by ops managed-by=ddns-cf/home" {
+		t.Errorf("Expected the other marker to be replaced, got %v", patches)
+	}
+}
+
+func TestCreateRecordOwnershipTXT(t *testing.T) {
+	conf = Config{DisableCFCache: true, OwnershipMarker: ownershipTXT}
+	defer func() { conf = Config{} }()
+
+	var created []map[string]any
+	record := newMockRecord(t, "new", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"success": true, "result": []}`))
+		case "POST":
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			created = append(created, body)
+			w.Write([]byte(`{"success": true, "result": {"id": "rec1"}}`))
+		}
+	})
+
+	if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created) != 2 {
+		t.Fatalf("Expected the record and the TXT record to be created, got %v", created)
+	}
+	if created[1]["type"] != "TXT" || created[1]["name"] != "_ddns-cf.new.example.com" || created[1]["content"] != `"managed-by=ddns-cf/default"` {
+		t.Errorf("Unexpected TXT record: %v", created[1])
+	}
+}
+
+func TestOwnerMarker(t *testing.T) {
+	defer func() { conf = Config{} }()
+
+	conf = Config{}
+	if ownerMarker() != "managed-by=ddns-cf/default" || ownerTag() != "managed-by:ddns-cf/default" {
+		t.Errorf("Unexpected default marker %q and tag %q", ownerMarker(), ownerTag())
+	}
+
+	conf = Config{InstanceID: "office"}
+	if ownerMarker() != "managed-by=ddns-cf/office" {
+		t.Errorf("Unexpected marker %q", ownerMarker())
+	}
+}
+
+// An instance shouldn't treat the records of an instance whose ID starts with its own as its own
+func TestIsOwnedComment(t *testing.T) {
+	conf = Config{InstanceID: "home"}
+	defer func() { conf = Config{} }()
+
+	for comment, expected := range map[string]bool{
+		"managed-by=ddns-cf/home":              true,
+		"set by ops managed-by=ddns-cf/home":   true,
+		"managed-by=ddns-cf/home-lab":          false,
+		"set by ops managed-by=ddns-cf/home2":  false,
+		"managed-by=ddns-cf/homelab-and-other": false,
+	} {
+		owned, err := isOwned(&Record{}, cloudflare.DNSRecord{Comment: comment})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owned != expected {
+			t.Errorf("Expected %t for %q, got %t", expected, comment, owned)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Set by the -dry-run flag. The changes are printed instead of being made in Cloudflare,
+// the cache is not used, the state files are read but not written, and the scripts are not run.
+var dryRun bool
+
+// The symbols printed before each change in dry-run mode.
+const (
+	planCreate    string = "+"
+	planUpdate    string = "~"
+	planDelete    string = "-"
+	planUnchanged string = "="
+	planError     string = "!"
+)
+
+// Prints a change that would be made to a record in dry-run mode.
+// e.g. "~ A     home.example.com  192.0.2.1 -> 192.0.2.2"
+func printPlan(symbol string, recordType string, name string, details ...string) {
+	fmt.Fprintf(os.Stdout, "%s %-5s %s  %s\n", symbol, recordType, name, strings.Join(details, " "))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Runs fn and returns what it printed to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	writer.Close()
+
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestDryRun(t *testing.T) {
+	conf = Config{DuplicateRecords: duplicatesUpdateOne}
+	dryRun = true
+	defer func() {
+		conf = Config{}
+		dryRun = false
+	}()
+
+	records := map[string]string{
+		"A":    `{"success": true, "result": [{"id": "rec1", "type": "A", "content": "192.0.2.1", "comment": "managed-by=ddns-cf/default"}, {"id": "rec2", "type": "A", "content": "192.0.2.9", "comment": "managed-by=ddns-cf/default"}]}`,
+		"AAAA": `{"success": true, "result": []}`,
+	}
+	record := newMockRecord(t, "plan", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Unexpected request in dry-run mode: %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(records[r.URL.Query().Get("type")]))
+	})
+	os.Remove(getCacheFilePath(record.fqdn, "A"))
+
+	out := captureStdout(t, func() {
+		if err := updateRecordIP(record, IPv4, net.ParseIP("192.0.2.2")); err != nil {
+			t.Error(err)
+		}
+		if err := updateRecordIP(record, IPv6, net.ParseIP("2001:db8::2")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	expected := []string{
+		"- A     plan.example.com  192.0.2.9",
+		"~ A     plan.example.com  192.0.2.1 -> 192.0.2.2",
+		"+ AAAA  plan.example.com  2001:db8::2",
+	}
+	if strings.TrimSpace(out) != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected plan:\n%s\nExpected:\n%s", out, strings.Join(expected, "\n"))
+	}
+
+	if _, err := getCachedIP(record, IPv4); err == nil {
+		t.Error("Expected the cache not to be written in dry-run mode")
+	}
+}
@@ -7,38 +7,61 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// Runs the script specified in the config file (if any) when an IP changes.
+// Runs the script specified in the config file (if any) when an IP changes, and sends the change or create notification.
 // The arguments are: IPversion, OldIP, NewIP, Updated FQDN
-func runUpdateScript(version IPVersion, oldIP, newIP net.IP) {
-	scriptPath := conf.ScriptOnChange
+func runUpdateScript(record *Record, version IPVersion, oldIP, newIP net.IP) {
+	if oldIP == nil {
+		notify(eventCreate, record, version, oldIP, newIP, nil)
+	} else {
+		notify(eventChange, record, version, oldIP, newIP, nil)
+	}
+
+	scriptPath := record.scriptOnChange()
 	if scriptPath == "" {
 		log.Info("[runUpdateScript] No script found")
 		return
 	}
 
-	out, err := exec.Command(scriptPath, string(version), ipToString(oldIP), ipToString(newIP), conf.name).Output()
+	if dryRun {
+		log.WithFields(log.Fields{"record": record.fqdn, "script": scriptPath}).Info("[runUpdateScript] Dry run. Not running the script")
+		return
+	}
+
+	out, err := exec.Command(scriptPath, string(version), ipToString(oldIP), ipToString(newIP), record.fqdn).Output()
 	if err != nil {
-		log.WithFields(log.Fields{"IPversion": version, "out": out, "err": err}).Error("[runUpdateScript] Error from script")
+		log.WithFields(log.Fields{"record": record.fqdn, "IPversion": version, "out": out, "err": err}).Error("[runUpdateScript] Error from script")
 		return
 	}
-	log.WithFields(log.Fields{"IPversion": version, "out": string(out)}).Info("[runUpdateScript] Script ran")
+	log.WithFields(log.Fields{"record": record.fqdn, "IPversion": version, "out": string(out)}).Info("[runUpdateScript] Script ran")
 }
 
-// Runs the script specified in the config file (if any) when there is an error updating the IP Address.
+// Runs the script specified in the config file (if any) when there is an error updating the IP Address, and sends the error notification.
 // The arguments are: error, IPversion, OldIP, NewIP, Updated FQDN
-func runErrorScript(err error, version IPVersion, oldIP, newIP net.IP) {
-	scriptPath := conf.ScriptOnError
+func runErrorScript(record *Record, err error, version IPVersion, oldIP, newIP net.IP) {
+	if runAborted() {
+		log.WithFields(log.Fields{"record": record.fqdn, "IPversion": version, "err": err}).Debug("[runErrorScript] The run was stopped. Not reporting the error")
+		return
+	}
+
+	notify(eventError, record, version, oldIP, newIP, err)
+
+	if dryRun {
+		printPlan(planError, version.getRecordType(), record.fqdn, err.Error())
+		return
+	}
+
+	scriptPath := record.scriptOnError()
 	if scriptPath == "" {
 		log.Info("[runErrorScript] No script found")
 		return
 	}
 
-	out, scriptErr := exec.Command(scriptPath, err.Error(), string(version), ipToString(oldIP), ipToString(newIP), conf.name).Output()
+	out, scriptErr := exec.Command(scriptPath, err.Error(), string(version), ipToString(oldIP), ipToString(newIP), record.fqdn).Output()
 	if scriptErr != nil {
-		log.WithFields(log.Fields{"err": err, "IPversion": version, "out": out, "scriptErr": scriptErr}).Error("[runErrorScript] Error from script")
+		log.WithFields(log.Fields{"record": record.fqdn, "err": err, "IPversion": version, "out": out, "scriptErr": scriptErr}).Error("[runErrorScript] Error from script")
 		return
 	}
-	log.WithFields(log.Fields{"out": string(out)}).Info("[runErrorScript] Script ran")
+	log.WithFields(log.Fields{"record": record.fqdn, "out": string(out)}).Info("[runErrorScript] Script ran")
 }
 
 func ipToString(ip net.IP) string {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Starts the HTTP server on ListenAddress in the background. Returns nil if no ListenAddress is set.
+func startHTTPServer() *http.Server {
+	if conf.ListenAddress == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/update", updateHandler)
+
+	server := &http.Server{
+		Addr:              conf.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.WithField("address", conf.ListenAddress).Info("[startHTTPServer] Listening")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithFields(log.Fields{"error": err, "address": conf.ListenAddress}).Error("[startHTTPServer] HTTP server failed")
+		}
+	}()
+
+	return server
+}
+
+// Stops the HTTP server, waiting a few seconds for the requests in progress.
+func stopHTTPServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithField("error", err).Error("[stopHTTPServer] Failed to stop the HTTP server")
+	}
+}
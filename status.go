@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The amount of failed runs in a row after which /healthz fails, when HealthMaxFailedRuns is not set.
+const defaultHealthMaxFailedRuns int = 3
+
+// The state of a record for an IP version, served on /status.
+type recordState struct {
+	Record string `json:"record"`
+	Type   string `json:"type"`
+	// The address the record points to
+	PublishedIP string `json:"publishedIP,omitempty"`
+	// The device's address that was detected on the last check
+	DetectedIP  string    `json:"detectedIP,omitempty"`
+	LastCheck   time.Time `json:"lastCheck"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// The state of the daemon, served on /status and used by /healthz.
+type daemonState struct {
+	mu sync.Mutex
+
+	LastRun     time.Time `json:"lastRun"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	// The amount of runs in a row where none of the records could be checked or updated
+	FailedRuns int            `json:"failedRuns"`
+	Records    []*recordState `json:"records"`
+
+	records map[string]*recordState
+}
+
+var state = daemonState{records: make(map[string]*recordState)}
+
+// Saves the outcome of checking the record. published is the address the record points to when err is nil.
+func (s *daemonState) recordChecked(record *Record, version IPVersion, detected net.IP, published net.IP, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := record.fqdn + "/" + version.getRecordType()
+	current, ok := s.records[key]
+	if !ok {
+		current = &recordState{Record: record.fqdn, Type: version.getRecordType()}
+		s.records[key] = current
+		s.Records = append(s.Records, current)
+		sort.Slice(s.Records, func(i, j int) bool {
+			return s.Records[i].Record+"/"+s.Records[i].Type < s.Records[j].Record+"/"+s.Records[j].Type
+		})
+	}
+
+	current.LastCheck = time.Now()
+	current.DetectedIP = ipToString(detected)
+	if err != nil {
+		current.LastError = err.Error()
+		return
+	}
+
+	current.LastError = ""
+	current.LastSuccess = current.LastCheck
+	current.PublishedIP = ipToString(published)
+}
+
+// Saves the outcome of a run.
+func (s *daemonState) runFinished(result runResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.LastRun = time.Now()
+	if result.exitCode() == exitFailure {
+		s.FailedRuns++
+		return
+	}
+	s.FailedRuns = 0
+	s.LastSuccess = s.LastRun
+}
+
+// Returns why the daemon is unhealthy, or "" if it is healthy.
+func (s *daemonState) unhealthyReason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maxFailedRuns := conf.HealthMaxFailedRuns
+	if maxFailedRuns <= 0 {
+		maxFailedRuns = defaultHealthMaxFailedRuns
+	}
+	if s.FailedRuns >= maxFailedRuns {
+		return "the last runs failed"
+	}
+
+	// Nothing to check before the first run ends
+	if conf.HealthMaxAge > 0 && !s.LastRun.IsZero() && time.Since(s.LastSuccess) > conf.HealthMaxAge {
+		return "the last successful run is too old"
+	}
+	return ""
+}
+
+// Liveness check. Fails when the last HealthMaxFailedRuns runs failed or the last success is older than HealthMaxAge.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if reason := state.unhealthyReason(); reason != "" {
+		http.Error(w, "unhealthy: "+reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Serves the state of the daemon and every record as JSON.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	state.mu.Lock()
+	data, err := json.MarshalIndent(&state, "", "  ")
+	state.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Receives the checks requested with POST /update. Read by runDaemon.
+var updateRequests = make(chan struct{}, 1)
+
+// Triggers an immediate check. Requires "Authorization: Bearer <UpdateToken>" when UpdateToken is set.
+func updateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if conf.UpdateToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(conf.UpdateToken)) != 1 {
+			log.WithField("remoteAddr", r.RemoteAddr).Warn("[updateHandler] Unauthorized update request")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// A check that is already pending covers this request too
+	select {
+	case updateRequests <- struct{}{}:
+	default:
+	}
+
+	log.WithField("remoteAddr", r.RemoteAddr).Info("[updateHandler] Check requested")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("update triggered\n"))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	defer func() {
+		conf = Config{}
+		state = daemonState{records: make(map[string]*recordState)}
+	}()
+
+	conf = Config{HealthMaxFailedRuns: 2}
+	state = daemonState{records: make(map[string]*recordState)}
+
+	check := func(expected int) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		healthzHandler(recorder, httptest.NewRequest("GET", "/healthz", nil))
+		if recorder.Code != expected {
+			t.Errorf("Expected status %d, got %d: %s", expected, recorder.Code, recorder.Body)
+		}
+	}
+
+	// Healthy before the first run
+	check(http.StatusOK)
+
+	failed := runResult{failed: 1}
+	state.runFinished(failed)
+	check(http.StatusOK)
+	state.runFinished(failed)
+	check(http.StatusServiceUnavailable)
+
+	// A partial failure resets the count
+	state.runFinished(runResult{succeeded: 1, failed: 1})
+	check(http.StatusOK)
+
+	conf.HealthMaxAge = time.Minute
+	state.LastSuccess = time.Now().Add(-2 * time.Minute)
+	check(http.StatusServiceUnavailable)
+}
+
+func TestStatus(t *testing.T) {
+	defer func() { state = daemonState{records: make(map[string]*recordState)} }()
+	state = daemonState{records: make(map[string]*recordState)}
+
+	home := &Record{fqdn: "home.example.com"}
+	nas := &Record{fqdn: "nas.example.com"}
+	state.recordChecked(nas, IPv6, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::20"), nil)
+	state.recordChecked(home, IPv4, net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.1"), nil)
+	state.recordChecked(home, IPv4, net.ParseIP("198.51.100.2"), nil, errors.New("API call failed"))
+
+	recorder := httptest.NewRecorder()
+	statusHandler(recorder, httptest.NewRequest("GET", "/status", nil))
+
+	var status struct {
+		Records []recordState `json:"records"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v", status.Records)
+	}
+
+	// The published address is kept after an error
+	got := status.Records[0]
+	if got.Record != "home.example.com" || got.PublishedIP != "198.51.100.1" || got.DetectedIP != "198.51.100.2" || got.LastError != "API call failed" {
+		t.Errorf("Unexpected status for home: %+v", got)
+	}
+	if status.Records[1].PublishedIP != "2001:db8::20" || status.Records[1].LastError != "" {
+		t.Errorf("Unexpected status for nas: %+v", status.Records[1])
+	}
+}
+
+func TestUpdateHandler(t *testing.T) {
+	defer func() { conf = Config{} }()
+	conf = Config{UpdateToken: "secret"}
+
+	send := func(method string, authorization string) int {
+		request := httptest.NewRequest(method, "/update", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		updateHandler(recorder, request)
+		return recorder.Code
+	}
+
+	if code := send("GET", "Bearer secret"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", code)
+	}
+	if code := send("POST", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", code)
+	}
+	if code := send("POST", "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with the wrong token, got %d", code)
+	}
+
+	if code := send("POST", "Bearer secret"); code != http.StatusAccepted {
+		t.Errorf("Expected 202, got %d", code)
+	}
+	// A second request while one is pending doesn't block
+	if code := send("POST", "Bearer secret"); code != http.StatusAccepted {
+		t.Errorf("Expected 202, got %d", code)
+	}
+
+	select {
+	case <-updateRequests:
+	default:
+		t.Error("Expected a check to be requested")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rtnetlink multicast groups from linux/rtnetlink.h. Not defined in syscall.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// A change to an address of a network interface.
+type addressEvent struct {
+	// The index of the interface
+	index   int
+	address net.IP
+	// true for RTM_DELADDR, false for RTM_NEWADDR
+	deleted bool
+}
+
+// Subscribes to rtnetlink address events and sends the name of the interface to changes when one of its
+// global addresses is added or removed. Only the interfaces in names are watched. It blocks until an error occurs.
+// The sends don't block: a check that is already pending covers the changes that happen before it runs.
+func watchInterfaces(names []string, changes chan<- string) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("Failed to open netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("Failed to bind netlink socket: %w", err)
+	}
+
+	watched := make(map[string]bool, len(names))
+	for _, name := range names {
+		watched[name] = true
+	}
+
+	buf := make([]byte, os.Getpagesize()*4)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.ENOBUFS {
+				// The kernel dropped events because they weren't read fast enough. Any of them could have been a change.
+				log.WithField("interfaces", names).Debug("[watchInterfaces] Netlink events were dropped. Checking anyway")
+				sendChange(changes, strings.Join(names, ","))
+				continue
+			}
+			return fmt.Errorf("Failed to read from netlink socket: %w", err)
+		}
+
+		events, err := parseAddressEvents(buf[:n])
+		if err != nil {
+			log.WithField("error", err).Warn("[watchInterfaces] Failed to parse netlink message")
+			continue
+		}
+
+		for _, event := range events {
+			iface, err := net.InterfaceByIndex(event.index)
+			if err != nil || !watched[iface.Name] {
+				continue
+			}
+
+			// Link-local addresses come and go with the link, they don't mean that the public address changed
+			if !event.address.IsGlobalUnicast() {
+				continue
+			}
+
+			log.WithFields(log.Fields{"interface": iface.Name, "address": event.address, "deleted": event.deleted}).Debug("[watchInterfaces] Address changed")
+			sendChange(changes, iface.Name)
+		}
+	}
+}
+
+// Sends name to changes unless a change is already waiting to be handled.
+func sendChange(changes chan<- string, name string) {
+	select {
+	case changes <- name:
+	default:
+	}
+}
+
+// Parses the RTM_NEWADDR and RTM_DELADDR messages in a netlink datagram. Other messages are ignored.
+func parseAddressEvents(data []byte) ([]addressEvent, error) {
+	messages, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []addressEvent
+	for _, m := range messages {
+		if m.Header.Type != syscall.RTM_NEWADDR && m.Header.Type != syscall.RTM_DELADDR {
+			continue
+		}
+
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		ifAddr := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+
+		// IFA_LOCAL is the local address on point-to-point links (e.g. ppp0), IFA_ADDRESS is the peer's there.
+		var address net.IP
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_LOCAL:
+				address = net.IP(attr.Value)
+			case syscall.IFA_ADDRESS:
+				if address == nil {
+					address = net.IP(attr.Value)
+				}
+			}
+		}
+
+		events = append(events, addressEvent{index: int(ifAddr.Index), address: address, deleted: m.Header.Type == syscall.RTM_DELADDR})
+	}
+	return events, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// Builds a netlink address message like the ones sent by the kernel
+func buildAddressMessage(msgType uint16, index uint32, address net.IP) []byte {
+	attr := make([]byte, syscall.SizeofRtAttr, syscall.SizeofRtAttr+len(address))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(address)))
+	binary.NativeEndian.PutUint16(attr[2:4], syscall.IFA_ADDRESS)
+	attr = append(attr, address...)
+
+	ifAddr := make([]byte, syscall.SizeofIfAddrmsg)
+	ifAddr[0] = syscall.AF_INET6
+	ifAddr[1] = 64
+	binary.NativeEndian.PutUint32(ifAddr[4:8], index)
+
+	header := make([]byte, syscall.SizeofNlMsghdr)
+	binary.NativeEndian.PutUint32(header[0:4], uint32(syscall.SizeofNlMsghdr+len(ifAddr)+len(attr)))
+	binary.NativeEndian.PutUint16(header[4:6], msgType)
+
+	return append(append(header, ifAddr...), attr...)
+}
+
+func TestParseAddressEvents(t *testing.T) {
+	data := buildAddressMessage(syscall.RTM_NEWADDR, 3, net.ParseIP("2001:db8::1"))
+	data = append(data, buildAddressMessage(syscall.RTM_DELADDR, 3, net.ParseIP("2001:db8::2"))...)
+	data = append(data, buildAddressMessage(syscall.RTM_NEWLINK, 3, net.ParseIP("2001:db8::3"))...)
+
+	events, err := parseAddressEvents(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].index != 3 || events[0].deleted || !events[0].address.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Unexpected first event: %+v", events[0])
+	}
+	if !events[1].deleted || !events[1].address.Equal(net.ParseIP("2001:db8::2")) {
+		t.Errorf("Unexpected second event: %+v", events[1])
+	}
+}
+
+func TestSendChangeDoesNotBlock(t *testing.T) {
+	changes := make(chan string, 1)
+	sendChange(changes, "ppp0")
+	// The channel is full. The change is covered by the pending one.
+	sendChange(changes, "eth0")
+
+	if name := <-changes; name != "ppp0" {
+		t.Errorf("Expected ppp0, got %s", name)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected a single pending change, got %d more", len(changes))
+	}
+}
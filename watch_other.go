@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Address change notifications are only available on Linux.
+func watchInterfaces(names []string, changes chan<- string) error {
+	return errors.New("watching interfaces is only supported on Linux")
+}
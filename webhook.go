@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// The functions available in the body templates.
+var templateFuncs = template.FuncMap{
+	// Returns the value as JSON, e.g. a quoted and escaped string
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// A Notifier that sends an HTTP request with a body made from a template.
+type webhookNotifier struct {
+	url     string
+	method  string
+	headers map[string]string
+	// If nil, the notification is sent as JSON
+	body   *template.Template
+	client *http.Client
+}
+
+func newWebhookNotifier(notifierConf NotifierConfig) (*webhookNotifier, error) {
+	if notifierConf.URL == "" {
+		return nil, errors.New("URL missing")
+	}
+	if _, err := url.ParseRequestURI(notifierConf.URL); err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(notifierConf.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	notifier := &webhookNotifier{url: notifierConf.URL, method: method, headers: notifierConf.Headers, client: &http.Client{}}
+
+	if notifierConf.Body != "" {
+		body, err := template.New("Body").Funcs(templateFuncs).Parse(notifierConf.Body)
+		if err != nil {
+			return nil, err
+		}
+		// Catches unknown fields before the first notification is sent
+		if err := body.Execute(io.Discard, Notification{}); err != nil {
+			return nil, err
+		}
+		notifier.body = body
+	}
+	return notifier, nil
+}
+
+// Returns the URL's host. The rest of the URL is left out since it usually has a secret in it.
+func (w *webhookNotifier) Name() string {
+	parsed, err := url.Parse(w.url)
+	if err != nil {
+		return "webhook"
+	}
+	return "webhook " + parsed.Host
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := w.renderBody(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+
+	return sendNotifierRequest(w.client, req)
+}
+
+// Sends the request and returns a notifierStatusError if the service answered with an error status.
+func sendNotifierRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL is left out of the error since it usually has a secret in it
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		// Only the start of the response is needed to know what went wrong
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &notifierStatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+	}
+	return nil
+}
+
+// Returns the body of the request: the template filled with the notification, or the notification as JSON.
+func (w *webhookNotifier) renderBody(notification Notification) ([]byte, error) {
+	if w.body == nil {
+		return json.Marshal(notification)
+	}
+
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, notification); err != nil {
+		return nil, fmt.Errorf("Error filling the Body template: %w", err)
+	}
+	return body.Bytes(), nil
+}